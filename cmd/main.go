@@ -39,9 +39,14 @@ func main() {
 	logger.Info("database", "Migrations have been successfully applied")
 
 	//подключаем роутер
-	h := handler.NewHandler(database, logger)
+	h := handler.NewHandler(database, logger, cfg)
 	r := handler.NewRouter(h)
 
+	//запускаем воркеров фоновой генерации
+	stopJobWorkers := h.StartJobWorkers()
+	//запускаем фоновую пометку просроченных ключей
+	stopExpirySweeper := h.StartExpirySweeper()
+
 	//запускаем сервер
 	logger.Info("server", "Starting HTTP server on "+cfg.Addr)
 
@@ -61,7 +66,9 @@ func main() {
 	logger.Info("server", "Initiating a graceful shutdown of the server")
 
 	// старт: выполнение каких-то функций перед завершением
-
+	stopJobWorkers()
+	logger.Info("jobs", "Background job workers stopped")
+	stopExpirySweeper()
 	// конец: выполнение каких-то функций перед завершением
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Партия - ключи одного вызова генерации. Каждый выпущенный ключ ссылается на
+// свою партию, поэтому её можно посчитать, выгрузить и отозвать целиком.
+
+var (
+	errBatchNotFound  = errors.New("batch not found")
+	errBadBatchFields = errors.New("batch name and created_by must be at most 255 characters")
+)
+
+type batch struct {
+	ID             int64           `json:"id"`
+	Name           string          `json:"name"`
+	Group          string          `json:"group"`
+	RequestedCount int             `json:"requested_count"`
+	CreatedBy      string          `json:"created_by,omitempty"`
+	Metadata       json.RawMessage `json:"metadata,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// batchStats - сколько ключей партии в каком состоянии
+type batchStats struct {
+	Issued      int64 `json:"issued"`
+	Active      int64 `json:"active"`
+	Redeemed    int64 `json:"redeemed"`
+	Revoked     int64 `json:"revoked"`
+	Expired     int64 `json:"expired"`
+	Redemptions int64 `json:"redemptions"`
+}
+
+const batchColumns = "id, name, group_name, requested_count, COALESCE(created_by, ''), metadata, created_at"
+
+func scanBatch(row rowScanner) (*batch, error) {
+	b := &batch{}
+	var metadata []byte
+	err := row.Scan(&b.ID, &b.Name, &b.Group, &b.RequestedCount, &b.CreatedBy, &metadata, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		b.Metadata = metadata
+	}
+	return b, nil
+}
+
+// newBatch собирает партию из параметров запроса генерации
+func newBatch(g *group, count int, name, createdBy string, metadata json.RawMessage, now time.Time) (*batch, error) {
+	if len(name) > 255 || len(createdBy) > 255 {
+		return nil, errBadBatchFields
+	}
+	if _, err := jsonObject(metadata); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s %s", g.Name, now.UTC().Format(time.RFC3339))
+	}
+	return &batch{
+		Name:           name,
+		Group:          g.Name,
+		RequestedCount: count,
+		CreatedBy:      createdBy,
+		Metadata:       metadata,
+		CreatedAt:      now,
+	}, nil
+}
+
+// insertBatch сохраняет партию и проставляет ей id
+func insertBatch(tx *sql.Tx, b *batch) error {
+	metadata, err := jsonObject(b.Metadata)
+	if err != nil {
+		return err
+	}
+	createdBy := sql.NullString{String: b.CreatedBy, Valid: b.CreatedBy != ""}
+	return tx.QueryRow(`INSERT INTO batches (name, group_name, requested_count, created_by, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		b.Name, b.Group, b.RequestedCount, createdBy, metadata, b.CreatedAt).Scan(&b.ID)
+}
+
+// ListBatchesHandler отдаёт партии страницами по id, ?group_name= фильтрует по группе
+func (h *Handler) ListBatchesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	after, limit, err := parsePage(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid page parameters: " + err.Error()})
+		return
+	}
+	groupName := r.URL.Query().Get("group_name")
+
+	rows, err := h.db.Query(`SELECT `+batchColumns+` FROM batches
+		WHERE ($1 = '' OR group_name = $1) AND id > $2 ORDER BY id LIMIT $3`, groupName, after, limit)
+	if err != nil {
+		h.logger.Error("handler: ListBatches", "Failed to list batches", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	defer rows.Close()
+
+	batches := []batch{}
+	for rows.Next() {
+		b, err := scanBatch(rows)
+		if err != nil {
+			h.logger.Error("handler: ListBatches", "Failed to scan batch", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
+		batches = append(batches, *b)
+	}
+	if err := rows.Err(); err != nil {
+		h.logger.Error("handler: ListBatches", "Failed to list batches", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	type ListBatchesResponse struct {
+		Batches   []batch `json:"batches"`
+		NextAfter int64   `json:"next_after,omitempty"`
+	}
+	response := &ListBatchesResponse{Batches: batches}
+	if len(batches) == limit {
+		response.NextAfter = batches[len(batches)-1].ID
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetBatchHandler отдаёт партию вместе со статистикой её ключей
+func (h *Handler) GetBatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	b, ok := h.batchFromRequest(w, r)
+	if !ok {
+		return
+	}
+	stats, err := h.getBatchStats(b.ID)
+	if err != nil {
+		h.logger.Error("handler: GetBatch", "Failed to count batch keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	type BatchResponse struct {
+		*batch
+		Stats *batchStats `json:"stats"`
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&BatchResponse{batch: b, Stats: stats})
+}
+
+// ExportBatchHandler выгружает ключи партии в CSV или NDJSON (?format=), см. export.go.
+// У ключей секретных групп в выгрузку попадает только префикс.
+func (h *Handler) ExportBatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	b, ok := h.batchFromRequest(w, r)
+	if !ok {
+		return
+	}
+	format, ok := parseExportFormat(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Format must be csv or ndjson"})
+		return
+	}
+
+	h.exportKeys(w, r, &keyFilter{BatchID: &b.ID}, format, fmt.Sprintf("batch-%d", b.ID))
+}
+
+// RevokeBatchHandler отзывает все действующие ключи партии
+func (h *Handler) RevokeBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var request keyAction
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if err := request.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	b, ok := h.batchFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.execBulkRevoke(fmt.Sprintf(bulkRevokeQuery, "batch_id = $7"), request, b.ID)
+	if err != nil {
+		h.logger.Error("handler: RevokeBatch", "Failed to revoke batch", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int64{"batch_id": b.ID, "revoked_count": revoked})
+}
+
+// batchFromRequest находит партию по {id} из пути. Если партии нет, ответ с
+// ошибкой уже записан и возвращается false.
+func (h *Handler) batchFromRequest(w http.ResponseWriter, r *http.Request) (*batch, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid batch id"})
+		return nil, false
+	}
+
+	b, err := h.getBatch(id)
+	if errors.Is(err, errBatchNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Batch not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.logger.Error("handler: Batch", "Failed to get batch", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return nil, false
+	}
+	return b, true
+}
+
+func (h *Handler) getBatch(id int64) (*batch, error) {
+	b, err := scanBatch(h.db.QueryRow("SELECT "+batchColumns+" FROM batches WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (h *Handler) getBatchStats(id int64) (*batchStats, error) {
+	stats := &batchStats{}
+	err := h.db.QueryRow(`SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE status = $2),
+			COUNT(*) FILTER (WHERE status = $3),
+			COUNT(*) FILTER (WHERE status = $4),
+			COUNT(*) FILTER (WHERE status = $5),
+			COALESCE(SUM(use_count), 0)
+		FROM keys WHERE batch_id = $1`,
+		id, keyStatusActive, keyStatusRedeemed, keyStatusRevoked, keyStatusExpired).
+		Scan(&stats.Issued, &stats.Active, &stats.Redeemed, &stats.Revoked, &stats.Expired, &stats.Redemptions)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func nullInt64(v *int64) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *v, Valid: true}
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+)
+
+const (
+	// сколько ключей уходит в базу одним INSERT
+	insertBatchSize = 1000
+	// сколько раундов подряд без единого нового ключа считаем исчерпанием пространства
+	maxEmptyRounds = 5
+)
+
+// keyInsertOptions - общие для всей пачки атрибуты выпускаемых ключей
+type keyInsertOptions struct {
+	jobID             sql.NullInt64
+	batchID           sql.NullInt64
+	window            validityWindow
+	maxUses           int
+	maxUsesPerSubject int
+	metadata          json.RawMessage
+	// предел issued_count группы, см. reserveCapacity
+	capacityLimit int64
+	// seed детерминированной генерации, см. seed.go
+	seed string
+}
+
+// generateAndInsertKeys создаёт партию b и выпускает в неё b.RequestedCount ключей
+// группы в одной транзакции. Либо в базу попадают партия и все ключи, либо ничего.
+func (h *Handler) generateAndInsertKeys(g *group, pattern *keygen.Pattern, b *batch, source *keygen.Source, opts keyInsertOptions) ([]string, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertBatch(tx, b); err != nil {
+		return nil, err
+	}
+	opts.batchID = sql.NullInt64{Int64: b.ID, Valid: true}
+	if err := reserveCapacity(tx, g.Name, b.RequestedCount, opts.capacityLimit); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if opts.seed != "" {
+		keys, err = h.insertSeededKeys(tx, g, pattern, b.RequestedCount, opts)
+	} else {
+		keys, err = h.insertGeneratedKeys(tx, g, pattern, b.RequestedCount, source, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// insertGeneratedKeys выпускает count ключей внутри переданной транзакции: кандидаты
+// собираются в памяти пачками, вставляются через INSERT ... ON CONFLICT DO NOTHING,
+// а перегенерируются только те, что столкнулись с уже выпущенными.
+func (h *Handler) insertGeneratedKeys(tx *sql.Tx, g *group, pattern *keygen.Pattern, count int, source *keygen.Source, opts keyInsertOptions) ([]string, error) {
+	if pattern.Permutation != nil {
+		return h.insertPermutedKeys(tx, g, pattern, count, opts)
+	}
+
+	keys := make([]string, 0, count)
+	// все кандидаты этого вызова, чтобы не предлагать базе один ключ дважды
+	seen := make(map[string]struct{}, count)
+	emptyRounds := 0
+	createdAt := time.Now()
+
+	for len(keys) < count {
+		need := min(count-len(keys), insertBatchSize)
+
+		candidates := make([]string, 0, need)
+		for attempts := 0; len(candidates) < need && attempts < need*keygen.CandidateAttemptsFactor; attempts++ {
+			key, ok := h.nextKey(pattern, source)
+			if !ok {
+				continue
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			candidates = append(candidates, key)
+		}
+
+		inserted := []string{}
+		if len(candidates) > 0 {
+			var err error
+			inserted, err = h.insertKeyBatch(tx, g, pattern, candidates, createdAt, opts)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(inserted) == 0 {
+			emptyRounds++
+			if emptyRounds >= maxEmptyRounds {
+				return nil, keygen.ErrKeyspaceExhausted
+			}
+			continue
+		}
+		emptyRounds = 0
+		keys = append(keys, inserted...)
+	}
+	return keys, nil
+}
+
+// insertKeyBatch вставляет пачку кандидатов и возвращает те, что реально легли в таблицу
+func (h *Handler) insertKeyBatch(tx *sql.Tx, g *group, pattern *keygen.Pattern, candidates []string, createdAt time.Time, opts keyInsertOptions) ([]string, error) {
+	metadata, err := jsonObject(opts.metadata)
+	if err != nil {
+		return nil, err
+	}
+	// после ротации ключа подписи тот же исходный ключ подписывается иначе, поэтому
+	// повтор ловит уникальный индекс по key_base, а не по подписанной строке
+	candidates, err = h.signKeys(g, candidates)
+	if err != nil {
+		return nil, err
+	}
+	bases := make([]string, len(candidates))
+	for i, key := range candidates {
+		bases[i] = h.keyBase(g, key).String
+	}
+
+	var (
+		rows *sql.Rows
+		// сохранённое значение (ключ или его HMAC) -> ключ
+		stored = make(map[string]string, len(candidates))
+	)
+
+	if g.Secret {
+		hashes := make([]string, len(candidates))
+		prefixes := make([]string, len(candidates))
+		for i, key := range candidates {
+			hashes[i] = h.hashKey(key).String
+			prefixes[i] = displayPrefix(key, pattern)
+			stored[hashes[i]] = key
+		}
+		rows, err = tx.Query(`INSERT INTO keys (key_hash, key_prefix, key_base, group_name, pattern, status, created_at, job_id,
+				valid_from, expires_at, max_uses, max_uses_per_subject, batch_id, metadata)
+			SELECT hash, prefix, NULLIF(base, ''), $3, $4, 'active', $5, $6, $7, $8, $9, $10, $11, $12::jsonb
+			FROM unnest($1::text[], $2::text[], $13::text[]) AS c(hash, prefix, base)
+			ON CONFLICT DO NOTHING
+			RETURNING key_hash`, hashes, prefixes, g.Name, pattern.Source, createdAt, opts.jobID,
+			nullTime(opts.window.ValidFrom), nullTime(opts.window.ExpiresAt), opts.maxUses, opts.maxUsesPerSubject, opts.batchID, metadata, bases)
+	} else {
+		for _, key := range candidates {
+			stored[key] = key
+		}
+		rows, err = tx.Query(`INSERT INTO keys (key_value, key_base, group_name, pattern, status, created_at, job_id,
+				valid_from, expires_at, max_uses, max_uses_per_subject, batch_id, metadata)
+			SELECT value, NULLIF(base, ''), $2, $3, 'active', $4, $5, $6, $7, $8, $9, $10, $11::jsonb
+			FROM unnest($1::text[], $12::text[]) AS c(value, base)
+			ON CONFLICT DO NOTHING
+			RETURNING key_value`, candidates, g.Name, pattern.Source, createdAt, opts.jobID,
+			nullTime(opts.window.ValidFrom), nullTime(opts.window.ExpiresAt), opts.maxUses, opts.maxUsesPerSubject, opts.batchID, metadata, bases)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inserted := make([]string, 0, len(candidates))
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		inserted = append(inserted, stored[value])
+	}
+	return inserted, rows.Err()
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net/http"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/go-chi/chi/v5"
+)
+
+// Ёмкость группы - число разных ключей, которые даёт её шаблон. Группа не выпускает
+// ключи сверх доли MaxKeyspaceFill от ёмкости: чем плотнее заполнено пространство, тем
+// чаще случайный кандидат совпадает с уже выпущенным ключом и тем дольше генерация.
+// Группы в режиме permuted не тянут случайных кандидатов и совпадений не знают,
+// поэтому для них предел - всё пространство ключей.
+// Выпущенные ключи считаются в groups.issued_count; фоновая задача резервирует
+// весь свой объём сразу при постановке в очередь.
+
+var errCapacityExceeded = errors.New("group capacity exceeded")
+
+type groupCapacity struct {
+	Total     *big.Int `json:"total"`
+	Used      int64    `json:"used"`
+	Remaining *big.Int `json:"remaining"`
+	// сколько ещё ключей можно выпустить, не превысив MaxFillRatio
+	Available    int64   `json:"available"`
+	FillRatio    float64 `json:"fill_ratio"`
+	MaxFillRatio float64 `json:"max_fill_ratio"`
+	// доля случайных кандидатов, которые совпадут с выпущенными ключами, и среднее
+	// число перегенераций на один новый ключ (null, если пространство заполнено)
+	CollisionRate         float64  `json:"collision_rate"`
+	ExpectedRetriesPerKey *float64 `json:"expected_retries_per_key"`
+
+	// предел issued_count для резервирования
+	limit int64
+}
+
+func newGroupCapacity(pattern *keygen.Pattern, used int64, maxFillRatio float64) *groupCapacity {
+	if pattern.Permutation != nil {
+		maxFillRatio = 1
+	}
+	total := pattern.KeyspaceSize()
+	c := &groupCapacity{
+		Total:        total,
+		Used:         used,
+		Remaining:    new(big.Int).Sub(total, big.NewInt(used)),
+		MaxFillRatio: maxFillRatio,
+	}
+	if c.Remaining.Sign() < 0 {
+		c.Remaining.SetInt64(0)
+	}
+
+	totalFloat, _ := new(big.Float).SetInt(total).Float64()
+	c.FillRatio = math.Min(float64(used)/totalFloat, 1)
+	c.CollisionRate = c.FillRatio
+	if pattern.Permutation != nil {
+		// номера счётчика не повторяются, перегенераций нет
+		c.CollisionRate = 0
+	}
+	if c.CollisionRate < 1 {
+		retries := c.CollisionRate / (1 - c.CollisionRate)
+		c.ExpectedRetriesPerKey = &retries
+	}
+
+	limit, _ := new(big.Float).Mul(new(big.Float).SetInt(total), big.NewFloat(maxFillRatio)).Int(nil)
+	if limit.IsInt64() {
+		c.limit = limit.Int64()
+	} else {
+		c.limit = math.MaxInt64
+	}
+	c.Available = max(c.limit-used, 0)
+	return c
+}
+
+func (h *Handler) capacityOf(g *group, pattern *keygen.Pattern) *groupCapacity {
+	return newGroupCapacity(pattern, g.IssuedCount, h.cfg.MaxKeyspaceFill)
+}
+
+func (h *Handler) GetGroupCapacityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	g, err := h.getGroup(chi.URLParam(r, "name"))
+	if errors.Is(err, errGroupNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown group"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler: GetGroupCapacity", "Failed to get group", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	pattern, err := g.keyPattern()
+	if err != nil {
+		h.logger.Error("handler: GetGroupCapacity", "Invalid pattern stored for group "+g.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.capacityOf(g, pattern))
+}
+
+// reserveCapacity увеличивает issued_count группы на count, если он не превысит limit
+func reserveCapacity(tx *sql.Tx, groupName string, count int, limit int64) error {
+	var issued int64
+	err := tx.QueryRow(`UPDATE groups SET issued_count = issued_count + $2
+		WHERE name = $1 AND issued_count + $2 <= $3 RETURNING issued_count`,
+		groupName, count, limit).Scan(&issued)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errCapacityExceeded
+	}
+	return err
+}
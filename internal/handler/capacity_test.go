@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/ids"
+)
+
+func TestNewGroupCapacity(t *testing.T) {
+	tests := []struct {
+		name         string
+		pattern      string
+		used         int64
+		maxFillRatio float64
+		// ожидаемые Total, Remaining, Available, FillRatio, CollisionRate и retries (-1 - null)
+		total, remaining string
+		available        int64
+		fillRatio        float64
+		collisionRate    float64
+		retries          float64
+	}{
+		{"empty group", "#{2}", 0, 0.5, "100", "100", 50, 0, 0, 0},
+		{"quarter filled", "#{2}", 25, 0.5, "100", "75", 25, 0.25, 0.25, 1.0 / 3},
+		{"at the fill limit", "#{2}", 50, 0.5, "100", "50", 0, 0.5, 0.5, 1},
+		{"over the fill limit", "#{2}", 60, 0.5, "100", "40", 0, 0.6, 0.6, 1.5},
+		{"whole keyspace allowed", "#{2}", 90, 1, "100", "10", 10, 0.9, 0.9, 9},
+		{"full keyspace", "#{2}", 100, 1, "100", "0", 0, 1, 1, -1},
+		// issued_count может обогнать ёмкость после смены настроек или импорта
+		{"more keys than the keyspace", "#{2}", 150, 1, "100", "0", 0, 1, 1, -1},
+		{"fractional limit rounds down", "#{1}", 0, 0.55, "10", "10", 5, 0, 0, 0},
+		{"mixed placeholders", "X@#%", 0, 1, "243360", "243360", 243360, 0, 0, 0},
+		// 36^20 не влезает в int64: предел обрезается до MaxInt64, доля почти ноль
+		{"keyspace over int64", "X{20}", 1000, 0.5, "13367494538843734067838845976576",
+			"13367494538843734067838845975576", math.MaxInt64 - 1000, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := keygen.New(tt.pattern, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newGroupCapacity(p, tt.used, tt.maxFillRatio)
+			if c.Total.String() != tt.total || c.Remaining.String() != tt.remaining {
+				t.Errorf("total, remaining = %s, %s, want %s, %s", c.Total, c.Remaining, tt.total, tt.remaining)
+			}
+			if c.Available != tt.available {
+				t.Errorf("available = %d, want %d", c.Available, tt.available)
+			}
+			if !closeTo(c.FillRatio, tt.fillRatio) || !closeTo(c.CollisionRate, tt.collisionRate) {
+				t.Errorf("fill ratio, collision rate = %v, %v, want %v, %v", c.FillRatio, c.CollisionRate, tt.fillRatio, tt.collisionRate)
+			}
+			switch {
+			case tt.retries < 0 && c.ExpectedRetriesPerKey != nil:
+				t.Errorf("expected retries = %v, want null", *c.ExpectedRetriesPerKey)
+			case tt.retries >= 0 && (c.ExpectedRetriesPerKey == nil || !closeTo(*c.ExpectedRetriesPerKey, tt.retries)):
+				t.Errorf("expected retries = %v, want %v", c.ExpectedRetriesPerKey, tt.retries)
+			}
+		})
+	}
+}
+
+// в режиме permuted совпадений нет, и выпускать можно всё пространство
+func TestNewGroupCapacityPermuted(t *testing.T) {
+	p, err := keygen.New("#{2}", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Permutation = keygen.NewPermutation(bytes.Repeat([]byte{1}, 32), p.KeyspaceSize())
+
+	c := newGroupCapacity(p, 60, 0.5)
+	if c.MaxFillRatio != 1 || c.limit != 100 || c.Available != 40 {
+		t.Errorf("max fill ratio, limit, available = %v, %d, %d, want 1, 100, 40", c.MaxFillRatio, c.limit, c.Available)
+	}
+	if c.CollisionRate != 0 || c.ExpectedRetriesPerKey == nil || *c.ExpectedRetriesPerKey != 0 {
+		t.Errorf("collision rate, retries = %v, %v, want 0, 0", c.CollisionRate, c.ExpectedRetriesPerKey)
+	}
+}
+
+func TestNewGroupCapacityOfIDs(t *testing.T) {
+	p, err := keygen.NewID(ids.UUIDv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newGroupCapacity(p, 0, 0.5)
+	want := new(big.Int).Lsh(big.NewInt(1), 122)
+	if c.Total.Cmp(want) != 0 || c.Available != math.MaxInt64 {
+		t.Errorf("total, available = %s, %d, want %s, %d", c.Total, c.Available, want, int64(math.MaxInt64))
+	}
+}
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) < 1e-9
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+)
+
+// Стоп-лист слов, которые не должны складываться в случайных частях ключа (ключи
+// promo печатаются на листовках). Слова и случайные части ключа сравниваются после
+// «сворачивания» leetspeak: регистр не важен, 0 = o, 1 = i = l, 3 = e, 4 = a и т.д.
+// Список загружается из файла при старте и меняется через API без рестарта.
+// Файл свой у каждого экземпляра: PUT меняет список только на принявшем его экземпляре,
+// поэтому при нескольких экземплярах список правится в файле деплоя, а затем на каждом
+// экземпляре вызывается reload.
+
+const (
+	// сколько раз generateKey перетягивает ключ, прежде чем сдаться
+	maxDenylistRedraws    = 100
+	minDenylistWordLength = 3
+)
+
+var errBadDenylistWord = fmt.Errorf("denylist words must have at least %d letters or digits", minDenylistWordLength)
+
+// похожие символы, которые сводятся к одной букве
+var leetFold = map[byte]byte{
+	'0': 'o', '1': 'i', '!': 'i', '|': 'i', 'l': 'i', '3': 'e', '4': 'a', '@': 'a',
+	'5': 's', '$': 's', '7': 't', '+': 't', '8': 'b', '9': 'g', '6': 'g', '2': 'z',
+}
+
+func foldLeet(s string) string {
+	folded := []byte(strings.ToLower(s))
+	for i, char := range folded {
+		if replacement, ok := leetFold[char]; ok {
+			folded[i] = replacement
+		}
+	}
+	return string(folded)
+}
+
+type denylist struct {
+	path string
+
+	mu     sync.RWMutex
+	words  []string
+	folded []string
+	// комментарии в начале файла, save сохраняет их
+	header []string
+
+	// сколько кандидатов отброшено, см. MetricsHandler
+	rejected atomic.Int64
+}
+
+// newDenylist загружает стоп-лист из файла; отсутствие файла - пустой список
+func newDenylist(path string) (*denylist, error) {
+	d := &denylist{path: path}
+	if path == "" {
+		return d, nil
+	}
+	if err := d.reload(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return d, err
+	}
+	return d, nil
+}
+
+// reload перечитывает файл: одно слово на строку, # - комментарий
+func (d *denylist) reload() error {
+	file, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var header []string
+	words := []string{}
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		word, _, _ := strings.Cut(lines.Text(), "#")
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		} else if len(words) == 0 {
+			header = append(header, lines.Text())
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+	if err := d.set(words); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.header = header
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *denylist) set(words []string) error {
+	unique := map[string]struct{}{}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		letters := 0
+		for i := 0; i < len(word); i++ {
+			if isAlphaNumeric(word[i]) {
+				letters++
+			}
+		}
+		if letters < minDenylistWordLength {
+			return fmt.Errorf("%w: %q", errBadDenylistWord, word)
+		}
+		unique[word] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(unique))
+	for word := range unique {
+		sorted = append(sorted, word)
+	}
+	sort.Strings(sorted)
+	folded := make([]string, len(sorted))
+	for i, word := range sorted {
+		folded[i] = stripSeparators(foldLeet(word))
+	}
+
+	d.mu.Lock()
+	d.words, d.folded = sorted, folded
+	d.mu.Unlock()
+	return nil
+}
+
+// save записывает текущий список обратно в файл, чтобы правки пережили рестарт
+func (d *denylist) save() error {
+	if d.path == "" {
+		return nil
+	}
+	d.mu.RLock()
+	content := strings.Join(append(slices.Clone(d.header), d.words...), "\n") + "\n"
+	d.mu.RUnlock()
+
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+func (d *denylist) list() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]string{}, d.words...)
+}
+
+// allows проверяет весь ключ после сворачивания и без разделителей, так что слово,
+// собранное из двух случайных частей, литерала или контрольного символа, тоже ловится.
+// Вхождение, целиком лежащее в литералах шаблона, не считается: их выбрали сами.
+// Ключи групп с подписью проверяются до подписи, и это намеренно: суффикс подписи -
+// это 16 (HMAC) или 103 (Ed25519) символа base32, в которых слова из стоп-листа
+// попадались бы постоянно, а перетянуть подпись нельзя - она задана самим ключом.
+func (d *denylist) allows(key string, pattern *keygen.Pattern) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.folded) == 0 {
+		return true
+	}
+
+	folded := foldLeet(key)
+	text := make([]byte, 0, len(folded))
+	// random[i] - символ text[i] выбран генератором (позиция шаблона или контрольный символ)
+	random := make([]bool, 0, len(folded))
+	for i := 0; i < len(folded); i++ {
+		if !isAlphaNumeric(folded[i]) {
+			continue
+		}
+		text = append(text, folded[i])
+		random = append(random, i >= len(pattern.Tokens) || pattern.Tokens[i].IsPlaceholder())
+	}
+
+	for _, word := range d.folded {
+		for from := 0; from < len(text); {
+			at := strings.Index(string(text[from:]), word)
+			if at < 0 {
+				break
+			}
+			at += from
+			if slices.Contains(random[at:at+len(word)], true) {
+				return false
+			}
+			from = at + 1
+		}
+	}
+	return true
+}
+
+// stripSeparators оставляет только буквы и цифры
+func stripSeparators(s string) string {
+	return strings.Map(func(char rune) rune {
+		if char < 128 && isAlphaNumeric(byte(char)) {
+			return char
+		}
+		return -1
+	}, s)
+}
+
+func isAlphaNumeric(char byte) bool {
+	return char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9'
+}
+
+func (h *Handler) GetDenylistHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"words": h.denylist.list()})
+}
+
+// UpdateDenylistHandler заменяет стоп-лист целиком и сохраняет его в файл
+func (h *Handler) UpdateDenylistHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Words []string `json:"words"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if err := h.denylist.set(request.Words); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid denylist: " + err.Error()})
+		return
+	}
+	if err := h.denylist.save(); err != nil {
+		h.logger.Error("handler: UpdateDenylist", "Failed to save denylist", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Denylist is applied but not saved to file"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"words": h.denylist.list()})
+}
+
+// ReloadDenylistHandler перечитывает стоп-лист из файла после ручной правки
+func (h *Handler) ReloadDenylistHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if h.denylist.path == "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Denylist file is not configured"})
+		return
+	}
+	if err := h.denylist.reload(); err != nil {
+		h.logger.Error("handler: ReloadDenylist", "Failed to reload denylist", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to reload denylist: " + err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{"words": h.denylist.list()})
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+)
+
+func TestFoldLeet(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"bad", "bad"},
+		{"BAD", "bad"},
+		{"B4D", "bad"},
+		{"8@D", "bad"},
+		{"L1!|", "iiii"},
+		{"5$7+", "sstt"},
+		{"0O3E", "ooee"},
+		{"9G6", "ggg"},
+		{"2Z", "zz"},
+		{"A-B_C", "a-b_c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			if got := foldLeet(tt.word); got != tt.want {
+				t.Errorf("foldLeet(%q) = %q, want %q", tt.word, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenylistAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		checksum string
+		key      string
+		want     bool
+	}{
+		{"clean key", "X{6}", "", "Q7ZK2M", true},
+		{"word in random part", "X{6}", "", "QBADZK", false},
+		{"word in lower case", "%{6}", "", "qbadzk", false},
+		{"leet word", "X{6}", "", "Q8@DZK", false},
+		{"leet digits", "X{6}", "", "QB4DZK", false},
+		{"word with a separator inside", "X{6}", "", "QB-ADZ", false},
+		{"word across random segments", "XX-XX", "", "BA-DQ", false},
+		{"word across a literal and random part", "BA-X{3}", "", "BA-DQZ", false},
+		{"word only in literals", "BAD-X{3}", "", "BAD-Q7Z", true},
+		{"escaped placeholder literal", `\X\X\X-X{3}`, "", "XXX-Q7Z", true},
+		{"checksum completes the word", "@{2}", "luhn", "BAD", false},
+		{"other word", "X{6}", "", "QEVILZ", false},
+		{"word split by literal separators", "X-X-X", "", "B-A-D", false},
+		{"letters of a word apart", "X{6}", "", "QBAZDK", true},
+	}
+	d := &denylist{}
+	if err := d.set([]string{"bad", "EVIL"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := keygen.New(tt.pattern, tt.checksum, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := d.allows(tt.key, p); got != tt.want {
+				t.Errorf("allows(%q, %q) = %v, want %v", tt.key, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyDenylistAllowsEverything(t *testing.T) {
+	p, err := keygen.New("X{3}", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(&denylist{}).allows("BAD", p) {
+		t.Error("empty denylist rejected a key")
+	}
+}
+
+func TestDenylistRejectsShortWords(t *testing.T) {
+	for _, words := range [][]string{{"ab"}, {"a-b"}, {"  "}, {"ok", "bad"}} {
+		if err := (&denylist{}).set(words); err == nil {
+			t.Errorf("set(%q) accepted a word shorter than %d letters", words, minDenylistWordLength)
+		}
+	}
+}
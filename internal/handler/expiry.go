@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Окно действия ключа задаётся на ключе (valid_from/expires_at при генерации, явно или
+// через TTL) и на группе. Ключ действует только на пересечении обоих окон, поэтому
+// закрытие окна группы сразу отключает все её ключи.
+
+const (
+	expirySweepInterval  = time.Minute
+	expirySweepBatchSize = 10000
+)
+
+var (
+	errKeyExpired      = errors.New("key expired")
+	errKeyNotYetActive = errors.New("key is not active yet")
+)
+
+// validityWindow - границы действия ключа, пустые значения означают «без ограничения»
+type validityWindow struct {
+	ValidFrom *time.Time `json:"valid_from,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// check проверяет, действует ли окно в момент now
+func (v validityWindow) check(now time.Time) error {
+	if v.ValidFrom != nil && now.Before(*v.ValidFrom) {
+		return errKeyNotYetActive
+	}
+	if v.ExpiresAt != nil && !now.Before(*v.ExpiresAt) {
+		return errKeyExpired
+	}
+	return nil
+}
+
+func (v validityWindow) validate() error {
+	if v.ValidFrom != nil && v.ExpiresAt != nil && !v.ExpiresAt.After(*v.ValidFrom) {
+		return errors.New("expires_at must be after valid_from")
+	}
+	return nil
+}
+
+// keyWindowFor считает окно выпускаемых ключей из параметров запроса и TTL группы
+func keyWindowFor(g *group, validFrom, expiresAt *time.Time, ttlSeconds int64, now time.Time) (validityWindow, error) {
+	if expiresAt != nil && ttlSeconds != 0 {
+		return validityWindow{}, errors.New("expires_at and ttl_seconds can't be used together")
+	}
+	if ttlSeconds < 0 {
+		return validityWindow{}, errors.New("ttl_seconds can't be negative")
+	}
+	if ttlSeconds == 0 && expiresAt == nil {
+		ttlSeconds = g.KeyTTLSeconds
+	}
+
+	window := validityWindow{ValidFrom: validFrom, ExpiresAt: expiresAt}
+	if ttlSeconds > 0 {
+		// TTL отсчитывается от начала действия ключа, а не от момента генерации
+		start := now
+		if validFrom != nil && validFrom.After(now) {
+			start = *validFrom
+		}
+		expires := start.Add(time.Duration(ttlSeconds) * time.Second)
+		window.ExpiresAt = &expires
+	}
+	if err := window.validate(); err != nil {
+		return validityWindow{}, err
+	}
+	if window.ExpiresAt != nil && !window.ExpiresAt.After(now) {
+		return validityWindow{}, errors.New("expires_at must be in the future")
+	}
+	return window, nil
+}
+
+// StartExpirySweeper периодически помечает просроченные ключи и удаляет
+// истёкшие ключи идемпотентности.
+// Возвращённая функция останавливает фоновый процесс и ждёт его завершения.
+func (h *Handler) StartExpirySweeper() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(expirySweepInterval)
+		defer ticker.Stop()
+
+		for {
+			h.sweepExpiredKeys(ctx)
+			h.sweepIdempotencyKeys(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// sweepExpiredKeys снимает с действия ключи с истёкшим окном - своим или группы.
+// Обновление идёт порциями, чтобы не держать блокировку на миллионах строк.
+func (h *Handler) sweepExpiredKeys(ctx context.Context) {
+	total := int64(0)
+	for ctx.Err() == nil {
+		result, err := h.db.ExecContext(ctx, `UPDATE keys SET status = $1
+			WHERE id IN (
+				SELECT k.id FROM keys k
+				LEFT JOIN groups g ON g.name = k.group_name
+				WHERE k.status = $2 AND (k.expires_at <= NOW() OR g.expires_at <= NOW())
+				LIMIT $3
+			)`, keyStatusExpired, keyStatusActive, expirySweepBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.logger.Error("expiry", "Failed to sweep expired keys", err)
+			}
+			return
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			h.logger.Error("expiry", "Failed to sweep expired keys", err)
+			return
+		}
+		total += affected
+		if affected < expirySweepBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		h.logger.Info("expiry", fmt.Sprintf("Marked %d keys as expired", total))
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyWindowFor(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name       string
+		groupTTL   int64
+		validFrom  *time.Time
+		expiresAt  *time.Time
+		ttlSeconds int64
+		want       validityWindow
+		wantErr    bool
+	}{
+		{name: "no limits", want: validityWindow{}},
+		{name: "absolute expiry", expiresAt: at(time.Hour), want: validityWindow{ExpiresAt: at(time.Hour)}},
+		{name: "ttl from now", ttlSeconds: 60, want: validityWindow{ExpiresAt: at(time.Minute)}},
+		{name: "group ttl", groupTTL: 3600, want: validityWindow{ExpiresAt: at(time.Hour)}},
+		{name: "request ttl overrides group ttl", groupTTL: 3600, ttlSeconds: 60, want: validityWindow{ExpiresAt: at(time.Minute)}},
+		{name: "expires_at overrides group ttl", groupTTL: 3600, expiresAt: at(time.Minute), want: validityWindow{ExpiresAt: at(time.Minute)}},
+		// TTL ключа, который начнёт действовать позже, отсчитывается от valid_from
+		{
+			name:       "ttl from future valid_from",
+			validFrom:  at(24 * time.Hour),
+			ttlSeconds: 60,
+			want:       validityWindow{ValidFrom: at(24 * time.Hour), ExpiresAt: at(24*time.Hour + time.Minute)},
+		},
+		{
+			name:       "ttl from past valid_from",
+			validFrom:  at(-24 * time.Hour),
+			ttlSeconds: 60,
+			want:       validityWindow{ValidFrom: at(-24 * time.Hour), ExpiresAt: at(time.Minute)},
+		},
+		{name: "valid_from only", validFrom: at(time.Hour), want: validityWindow{ValidFrom: at(time.Hour)}},
+		{name: "expires_at with ttl", expiresAt: at(time.Hour), ttlSeconds: 60, wantErr: true},
+		{name: "negative ttl", ttlSeconds: -1, wantErr: true},
+		{name: "expires_at is now", expiresAt: at(0), wantErr: true},
+		{name: "expires_at in the past", expiresAt: at(-time.Second), wantErr: true},
+		{name: "expires_at equals valid_from", validFrom: at(time.Hour), expiresAt: at(time.Hour), wantErr: true},
+		{name: "expires_at before valid_from", validFrom: at(time.Hour), expiresAt: at(time.Minute), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keyWindowFor(&group{KeyTTLSeconds: tt.groupTTL}, tt.validFrom, tt.expiresAt, tt.ttlSeconds, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("keyWindowFor() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("keyWindowFor(): %v", err)
+			}
+			if !sameTime(got.ValidFrom, tt.want.ValidFrom) || !sameTime(got.ExpiresAt, tt.want.ExpiresAt) {
+				t.Errorf("keyWindowFor() = %s, want %s", describeWindow(got), describeWindow(tt.want))
+			}
+		})
+	}
+}
+
+func TestValidityWindowCheck(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	window := validityWindow{ValidFrom: &from, ExpiresAt: &to}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want error
+	}{
+		{"before valid_from", from.Add(-time.Nanosecond), errKeyNotYetActive},
+		// valid_from включается в окно, expires_at - нет
+		{"at valid_from", from, nil},
+		{"inside", from.Add(time.Minute), nil},
+		{"just before expires_at", to.Add(-time.Nanosecond), nil},
+		{"at expires_at", to, errKeyExpired},
+		{"after expires_at", to.Add(time.Second), errKeyExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := window.check(tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("check(%s) = %v, want %v", tt.now, err, tt.want)
+			}
+		})
+	}
+
+	if err := (validityWindow{}).check(from); err != nil {
+		t.Errorf("check() of an open window = %v, want nil", err)
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func describeWindow(v validityWindow) string {
+	describe := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	}
+	return "[" + describe(v.ValidFrom) + ", " + describe(v.ExpiresAt) + ")"
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Выгрузка читает ключи через серверный курсор порциями по exportFetchSize и сразу
+// пишет их в ответ, поэтому память не зависит от размера выгрузки.
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+
+	exportFetchSize = 1000
+)
+
+var exportCSVHeader = []string{"key", "key_prefix", "group", "status", "created_at", "valid_from", "expires_at",
+	"use_count", "max_uses", "batch_id", "metadata"}
+
+// ExportKeysHandler выгружает ключи в CSV или NDJSON (?format=). Фильтры те же, что
+// у списка ключей; group и batch - короткие имена для group_name и id партии.
+func (h *Handler) ExportKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	filter, err := parseKeyFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid filter: " + err.Error()})
+		return
+	}
+	if filter.Group == "" {
+		filter.Group = r.URL.Query().Get("group")
+	}
+	format, ok := parseExportFormat(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Format must be csv or ndjson"})
+		return
+	}
+
+	h.exportKeys(w, r, filter, format, fmt.Sprintf("keys-%s", time.Now().UTC().Format("20060102-150405")))
+}
+
+// parseExportFormat читает ?format=, по умолчанию CSV
+func parseExportFormat(r *http.Request) (string, bool) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		return exportFormatCSV, true
+	case exportFormatCSV, exportFormatNDJSON:
+		return format, true
+	default:
+		return "", false
+	}
+}
+
+// exportKeys пишет в ответ все ключи, подходящие под filter. Пока ответ не начат,
+// ошибки отдаются клиенту как обычно, после - только логируются.
+func (h *Handler) exportKeys(w http.ResponseWriter, r *http.Request, filter *keyFilter, format, filename string) {
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		h.logger.Error("handler: ExportKeys", "Failed to start export", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	where, args := filter.where()
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("DECLARE export_keys NO SCROLL CURSOR FOR SELECT %s FROM keys WHERE %s ORDER BY id",
+		keyInfoColumns, where), args...)
+	if err != nil {
+		h.logger.Error("handler: ExportKeys", "Failed to open export cursor", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == exportFormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var out io.Writer = w
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	w.WriteHeader(http.StatusOK)
+
+	writer := newKeyExportWriter(out, format)
+	if err := writer.header(); err != nil {
+		h.logger.Error("handler: ExportKeys", "Failed to write export", err)
+		return
+	}
+	for {
+		written, err := h.exportChunk(ctx, tx, writer)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.logger.Error("handler: ExportKeys", "Failed to export keys", err)
+			}
+			return
+		}
+		if err := writer.flush(); err != nil {
+			h.logger.Error("handler: ExportKeys", "Failed to write export", err)
+			return
+		}
+		if gz, ok := out.(*gzip.Writer); ok {
+			gz.Flush()
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if written < exportFetchSize {
+			return
+		}
+	}
+}
+
+// exportChunk читает из курсора одну порцию и возвращает число записанных ключей
+func (h *Handler) exportChunk(ctx context.Context, tx *sql.Tx, writer *keyExportWriter) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM export_keys", exportFetchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	written := 0
+	for rows.Next() {
+		k, err := scanKeyInfo(rows)
+		if err != nil {
+			return written, err
+		}
+		if err := writer.write(k); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, rows.Err()
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// keyExportWriter пишет ключи в выбранном формате
+type keyExportWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func newKeyExportWriter(out io.Writer, format string) *keyExportWriter {
+	if format == exportFormatNDJSON {
+		return &keyExportWriter{json: json.NewEncoder(out)}
+	}
+	return &keyExportWriter{csv: csv.NewWriter(out)}
+}
+
+func (e *keyExportWriter) header() error {
+	if e.csv == nil {
+		return nil
+	}
+	return e.csv.Write(exportCSVHeader)
+}
+
+func (e *keyExportWriter) write(k *keyInfo) error {
+	if e.json != nil {
+		return e.json.Encode(k)
+	}
+
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	batchID := ""
+	if k.BatchID != nil {
+		batchID = strconv.FormatInt(*k.BatchID, 10)
+	}
+	return e.csv.Write([]string{k.Key, k.KeyPrefix, k.Group, k.Status, k.CreatedAt.Format(time.RFC3339),
+		formatTime(k.ValidFrom), formatTime(k.ExpiresAt), strconv.Itoa(k.UseCount), strconv.Itoa(k.MaxUses),
+		batchID, string(k.Metadata)})
+}
+
+func (e *keyExportWriter) flush() error {
+	if e.csv == nil {
+		return nil
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
@@ -0,0 +1,536 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/keysign"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxGroupNameLength = 100
+
+var (
+	errGroupNotFound      = errors.New("group not found")
+	errGroupAlreadyExists = errors.New("group already exists")
+	errGroupHasLiveKeys   = errors.New("group has live keys")
+	errGroupChanged       = errors.New("group was changed by another request")
+	errLowEntropy         = errors.New("pattern entropy is below the group minimum")
+	errNoPermutationKey   = errors.New("permuted group has no permutation key")
+)
+
+// колонки таблицы groups в порядке полей scanGroup
+const groupColumns = "name, pattern, checksum, alphabet, normalize, min_entropy_bits, secret, valid_from, expires_at, key_ttl_seconds, max_uses, max_uses_per_subject, metadata, issued_count, generation, permutation_key, key_counter, signature, kind, created_at, updated_at"
+
+type group struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+	// вид стандартного идентификатора вместо шаблона, см. ids.go
+	Kind     string `json:"kind,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	// алфавит позиции X и приведение похожих символов при проверке, см. keygen/alphabet.go
+	Alphabet  string `json:"alphabet,omitempty"`
+	Normalize bool   `json:"normalize"`
+	// минимальная энтропия случайной части ключа, ниже которой группа не генерирует ключи
+	MinEntropyBits int `json:"min_entropy_bits"`
+	// ключи секретной группы хранятся только в виде HMAC, см. secret.go
+	Secret bool `json:"secret"`
+	// окно действия всех ключей группы и TTL ключа по умолчанию, см. expiry.go
+	ValidFrom     *time.Time `json:"valid_from,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	KeyTTLSeconds int64      `json:"key_ttl_seconds,omitempty"`
+	// лимиты использования ключей по умолчанию: всего и на одного клиента (0 - без лимита)
+	MaxUses           int `json:"max_uses"`
+	MaxUsesPerSubject int `json:"max_uses_per_subject"`
+	// метаданные, которые получает каждый выпущенный ключ группы, см. metadata.go
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// выпущено и зарезервировано задачами ключей, см. capacity.go
+	IssuedCount int64 `json:"issued_count"`
+	// способ выпуска ключей и счётчик номеров режима permuted, см. permutation.go
+	Generation     string `json:"generation"`
+	PermutationKey []byte `json:"-"`
+	KeyCounter     int64  `json:"key_counter"`
+	// алгоритм подписи ключей для офлайн-проверки, см. signing.go
+	Signature string    `json:"signature,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// groupSettings - настраиваемые поля группы, которые принимают POST и PUT.
+// PUT накладывает тело запроса на текущие настройки: поля, которых нет в теле, не меняются.
+type groupSettings struct {
+	Pattern        string     `json:"pattern"`
+	Kind           string     `json:"kind"`
+	Checksum       string     `json:"checksum"`
+	Alphabet       string     `json:"alphabet"`
+	Normalize      bool       `json:"normalize"`
+	MinEntropyBits int        `json:"min_entropy_bits"`
+	Secret         bool       `json:"secret"`
+	ValidFrom      *time.Time `json:"valid_from"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	KeyTTLSeconds  int64      `json:"key_ttl_seconds"`
+	// 0 в max_uses означает одноразовые ключи
+	MaxUses           int             `json:"max_uses"`
+	MaxUsesPerSubject int             `json:"max_uses_per_subject"`
+	Metadata          json.RawMessage `json:"metadata"`
+	Generation        string          `json:"generation"`
+	Signature         string          `json:"signature"`
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanGroup(row rowScanner) (*group, error) {
+	g := &group{}
+	var (
+		validFrom, expiresAt sql.NullTime
+		metadata             []byte
+	)
+	err := row.Scan(&g.Name, &g.Pattern, &g.Checksum, &g.Alphabet, &g.Normalize, &g.MinEntropyBits, &g.Secret,
+		&validFrom, &expiresAt, &g.KeyTTLSeconds, &g.MaxUses, &g.MaxUsesPerSubject, &metadata, &g.IssuedCount,
+		&g.Generation, &g.PermutationKey, &g.KeyCounter, &g.Signature, &g.Kind, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		g.Metadata = metadata
+	}
+	g.ValidFrom = timePtr(validFrom)
+	g.ExpiresAt = timePtr(expiresAt)
+	return g, nil
+}
+
+// settings возвращает текущие настраиваемые поля группы
+func (g *group) settings() groupSettings {
+	return groupSettings{
+		Pattern:           g.Pattern,
+		Kind:              g.Kind,
+		Checksum:          g.Checksum,
+		Alphabet:          g.Alphabet,
+		Normalize:         g.Normalize,
+		MinEntropyBits:    g.MinEntropyBits,
+		Secret:            g.Secret,
+		ValidFrom:         g.ValidFrom,
+		ExpiresAt:         g.ExpiresAt,
+		KeyTTLSeconds:     g.KeyTTLSeconds,
+		MaxUses:           g.MaxUses,
+		MaxUsesPerSubject: g.MaxUsesPerSubject,
+		Metadata:          g.Metadata,
+		Generation:        g.Generation,
+		Signature:         g.Signature,
+	}
+}
+
+func (g *group) window() validityWindow {
+	return validityWindow{ValidFrom: g.ValidFrom, ExpiresAt: g.ExpiresAt}
+}
+
+// keyPattern собирает разобранный шаблон с настройками группы
+func (g *group) keyPattern() (*keygen.Pattern, error) {
+	if g.Kind != "" {
+		return keygen.NewID(g.Kind)
+	}
+	p, err := keygen.New(g.Pattern, g.Checksum, g.Alphabet)
+	if err != nil {
+		return nil, err
+	}
+	p.Normalize = g.Normalize
+	if g.Generation == generationPermuted {
+		if len(g.PermutationKey) == 0 {
+			return nil, errNoPermutationKey
+		}
+		p.Permutation = keygen.NewPermutation(g.PermutationKey, p.KeyspaceSize())
+	}
+	return p, nil
+}
+
+// checkEntropy сравнивает энтропию шаблона с минимумом группы
+func (g *group) checkEntropy(p *keygen.Pattern) error {
+	if bits := p.EntropyBits(); bits < float64(g.MinEntropyBits) {
+		return fmt.Errorf("%w: %.1f < %d bits", errLowEntropy, bits, g.MinEntropyBits)
+	}
+	return nil
+}
+
+// validateUsageLimits проверяет лимиты использования ключа
+func validateUsageLimits(maxUses, maxUsesPerSubject int) error {
+	if maxUses < 1 {
+		return errors.New("max_uses must be at least 1")
+	}
+	if maxUsesPerSubject < 0 || maxUsesPerSubject > maxUses {
+		return errors.New("max_uses_per_subject must be between 0 and max_uses")
+	}
+	return nil
+}
+
+// permutationKey выдаёт новый ключ перестановки для режима permuted. Ключ группы
+// задаётся один раз и не меняется: иначе новые номера совпадали бы со старыми ключами.
+func (settings groupSettings) permutationKey() []byte {
+	if settings.Generation != generationPermuted {
+		return nil
+	}
+	return keygen.NewPermutationKey()
+}
+
+// validateGroupSettings проверяет шаблон и настройки группы перед сохранением
+// и подставляет значения по умолчанию
+func validateGroupSettings(settings *groupSettings) error {
+	if settings.MaxUses == 0 {
+		settings.MaxUses = 1
+	}
+	if err := validateUsageLimits(settings.MaxUses, settings.MaxUsesPerSubject); err != nil {
+		return err
+	}
+	if _, err := jsonObject(settings.Metadata); err != nil {
+		return err
+	}
+	switch settings.Generation {
+	case "":
+		settings.Generation = generationRandom
+	case generationRandom, generationPermuted:
+	default:
+		return errBadGeneration
+	}
+	if settings.Signature != "" && keysign.SignatureLength(settings.Signature) == 0 {
+		return errBadSignatureAlgorithm
+	}
+	if settings.MinEntropyBits < 0 {
+		return errors.New("min_entropy_bits can't be negative")
+	}
+	if settings.KeyTTLSeconds < 0 {
+		return errors.New("key_ttl_seconds can't be negative")
+	}
+	if err := (validityWindow{ValidFrom: settings.ValidFrom, ExpiresAt: settings.ExpiresAt}).validate(); err != nil {
+		return err
+	}
+	if settings.Kind != "" && (settings.Pattern != "" || settings.Checksum != "" || settings.Alphabet != "" ||
+		settings.Normalize || settings.Generation == generationPermuted) {
+		return errKindSettings
+	}
+	g := &group{Pattern: settings.Pattern, Kind: settings.Kind, Checksum: settings.Checksum, Alphabet: settings.Alphabet, MinEntropyBits: settings.MinEntropyBits}
+	p, err := g.keyPattern()
+	if err != nil {
+		return err
+	}
+	if p.Kind == "" {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	if settings.Signature != "" && p.KeyLength()+keysign.Overhead(settings.Signature) > keygen.MaxKeyLength {
+		return fmt.Errorf("%w with %s signature", keygen.ErrKeyTooLong, settings.Signature)
+	}
+	return g.checkEntropy(p)
+}
+
+func (h *Handler) CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+		groupSettings
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if request.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Name is required"})
+		return
+	}
+	if len(request.Name) > maxGroupNameLength {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Name is too long"})
+		return
+	}
+	if err := validateGroupSettings(&request.groupSettings); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid group settings: " + err.Error()})
+		return
+	}
+
+	created, err := h.createGroup(request.Name, request.groupSettings)
+	if errors.Is(err, errGroupAlreadyExists) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group already exists"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler: CreateGroup", "Failed to create group", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// UpdateGroupHandler меняет переданные в теле настройки группы, остальные остаются
+// прежними; шаблон и всё, по чему проверяются ключи, нельзя менять, пока у группы
+// есть непогашенные ключи
+func (h *Handler) UpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	current, err := h.getGroup(chi.URLParam(r, "name"))
+	if errors.Is(err, errGroupNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown group"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler: UpdateGroup", "Failed to get group", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	request := current.settings()
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validateGroupSettings(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid group settings: " + err.Error()})
+		return
+	}
+
+	updated, err := h.updateGroup(current, request)
+	switch {
+	case errors.Is(err, errGroupNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown group"})
+		return
+	case errors.Is(err, errGroupChanged):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group was changed by another request, retry"})
+		return
+	case errors.Is(err, errGroupHasLiveKeys):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid group settings: " + err.Error()})
+		return
+	case errors.Is(err, errSecretNotConfigured):
+		h.logger.Error("handler", "Key pepper is not configured for secret group "+chi.URLParam(r, "name"), nil)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Secret groups are not configured"})
+		return
+	case errors.Is(err, errSecretHasHashedKeys):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group has hashed keys, secret can't be turned off"})
+		return
+	case err != nil:
+		h.logger.Error("handler: UpdateGroup", "Failed to update group", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteGroupHandler удаляет группу; если у группы остались непогашенные ключи,
+// удаление возможно только с ?force=true, сами ключи при этом остаются в таблице
+func (h *Handler) DeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	force := r.URL.Query().Get("force") == "true"
+
+	err := h.deleteGroup(chi.URLParam(r, "name"), force)
+	switch {
+	case errors.Is(err, errGroupNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown group"})
+		return
+	case errors.Is(err, errGroupHasLiveKeys):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group has live keys, use force=true to delete it anyway"})
+		return
+	case err != nil:
+		h.logger.Error("handler: DeleteGroup", "Failed to delete group", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getGroup(name string) (*group, error) {
+	g, err := scanGroup(h.db.QueryRow("SELECT "+groupColumns+" FROM groups WHERE name = $1", name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (h *Handler) listGroups() ([]group, error) {
+	rows, err := h.db.Query("SELECT " + groupColumns + " FROM groups ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *g)
+	}
+	return groups, rows.Err()
+}
+
+func (h *Handler) createGroup(name string, settings groupSettings) (*group, error) {
+	metadata, err := jsonObject(settings.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	g, err := scanGroup(h.db.QueryRow(`INSERT INTO groups (name, pattern, checksum, alphabet, normalize, min_entropy_bits, secret,
+			valid_from, expires_at, key_ttl_seconds, max_uses, max_uses_per_subject, metadata, generation, permutation_key, signature, kind)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (name) DO NOTHING RETURNING `+groupColumns,
+		name, settings.Pattern, settings.Checksum, settings.Alphabet, settings.Normalize, settings.MinEntropyBits, settings.Secret,
+		nullTime(settings.ValidFrom), nullTime(settings.ExpiresAt), settings.KeyTTLSeconds,
+		settings.MaxUses, settings.MaxUsesPerSubject, metadata, settings.Generation, settings.permutationKey(), settings.Signature, settings.Kind))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errGroupAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// updateGroup сохраняет настройки, собранные поверх base. Если группу успели изменить
+// после чтения base, обновление отклоняется, иначе оно затёрло бы чужие изменения
+// полями, которых не было в запросе.
+func (h *Handler) updateGroup(base *group, settings groupSettings) (*group, error) {
+	name := base.Name
+	metadata, err := jsonObject(settings.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanGroup(tx.QueryRow("SELECT "+groupColumns+" FROM groups WHERE name = $1 FOR UPDATE", name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !current.UpdatedAt.Equal(base.UpdatedAt) {
+		return nil, errGroupChanged
+	}
+	if changed := keyFormatChanges(current, settings); len(changed) > 0 {
+		live, err := hasLiveKeys(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		if live {
+			return nil, fmt.Errorf("%w: can't change %s", errGroupHasLiveKeys, strings.Join(changed, ", "))
+		}
+	}
+	if current.Secret != settings.Secret {
+		if err := h.changeSecret(tx, current, settings.Secret); err != nil {
+			return nil, err
+		}
+	}
+
+	g, err := scanGroup(tx.QueryRow(`UPDATE groups SET pattern = $2, checksum = $3, alphabet = $4, normalize = $5,
+		min_entropy_bits = $6, secret = $7, valid_from = $8, expires_at = $9, key_ttl_seconds = $10, max_uses = $11,
+		max_uses_per_subject = $12, metadata = $13, generation = $14, permutation_key = COALESCE(permutation_key, $15),
+		signature = $16, kind = $17, updated_at = $18
+		WHERE name = $1 RETURNING `+groupColumns,
+		name, settings.Pattern, settings.Checksum, settings.Alphabet, settings.Normalize, settings.MinEntropyBits, settings.Secret,
+		nullTime(settings.ValidFrom), nullTime(settings.ExpiresAt), settings.KeyTTLSeconds,
+		settings.MaxUses, settings.MaxUsesPerSubject, metadata, settings.Generation, settings.permutationKey(), settings.Signature, settings.Kind, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return g, tx.Commit()
+}
+
+func (h *Handler) deleteGroup(name string, force bool) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// блокируем группу на время проверки ключей
+	var locked string
+	err = tx.QueryRow("SELECT name FROM groups WHERE name = $1 FOR UPDATE", name).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errGroupNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		live, err := hasLiveKeys(tx, name)
+		if err != nil {
+			return err
+		}
+		if live {
+			return errGroupHasLiveKeys
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM groups WHERE name = $1", name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func hasLiveKeys(tx *sql.Tx, name string) (bool, error) {
+	var live bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM keys WHERE group_name = $1 AND status = $2)", name, keyStatusActive).Scan(&live)
+	return live, err
+}
+
+// keyFormatChanges перечисляет изменённые настройки, от которых зависит проверка
+// уже выпущенных ключей: у ключа нет своей копии настроек, он проверяется по группе.
+// Режим выпуска (generation) сюда не входит: он влияет только на новые ключи, а
+// совпадения со старыми insertPermutedKeys пропускает.
+func keyFormatChanges(current *group, settings groupSettings) []string {
+	var changed []string
+	for _, field := range []struct {
+		name     string
+		modified bool
+	}{
+		{"pattern", current.Pattern != settings.Pattern},
+		{"checksum", current.Checksum != settings.Checksum},
+		{"alphabet", current.Alphabet != settings.Alphabet},
+		{"normalize", current.Normalize != settings.Normalize},
+		{"signature", current.Signature != settings.Signature},
+		{"kind", current.Kind != settings.Kind},
+	} {
+		if field.modified {
+			changed = append(changed, field.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupSettingsCopiesEveryField(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	g := &group{
+		Name: "promo", Pattern: "AV-X{8}", Kind: "uuidv7", Checksum: "luhn", Alphabet: "crockford",
+		Normalize: true, MinEntropyBits: 40, Secret: true, ValidFrom: &from, ExpiresAt: &to, KeyTTLSeconds: 60,
+		MaxUses: 3, MaxUsesPerSubject: 1, Metadata: json.RawMessage(`{"discount":10}`),
+		Generation: generationPermuted, Signature: "hmac-sha256",
+	}
+	settings := reflect.ValueOf(g.settings())
+	for i := 0; i < settings.NumField(); i++ {
+		if settings.Field(i).IsZero() {
+			t.Errorf("settings() dropped %s", settings.Type().Field(i).Name)
+		}
+	}
+}
+
+// поля, которых нет в теле PUT, остаются прежними, а явный null сбрасывает значение
+func TestPartialGroupUpdate(t *testing.T) {
+	expires := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	g := &group{
+		Pattern: "AV-X{8}", Secret: true, ExpiresAt: &expires, MaxUses: 5, MaxUsesPerSubject: 2,
+		Metadata: json.RawMessage(`{"discount":10}`), Generation: generationPermuted,
+	}
+	settings := g.settings()
+	body := `{"max_uses": 10, "expires_at": null, "metadata": null}`
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&settings); err != nil {
+		t.Fatal(err)
+	}
+
+	want := g.settings()
+	want.MaxUses = 10
+	want.ExpiresAt = nil
+	want.Metadata = json.RawMessage("null")
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("settings after PUT %s = %+v, want %+v", body, settings, want)
+	}
+	if metadata, _ := jsonObject(settings.Metadata); metadata != nil {
+		t.Errorf("metadata = %v, want it cleared", metadata)
+	}
+}
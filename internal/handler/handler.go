@@ -3,24 +3,69 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
-	"math/rand"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
-	"unicode"
 
+	"github.com/IvanChernomyrdin/avito-key-generate/config"
 	"github.com/IvanChernomyrdin/avito-key-generate/config/db"
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/ids"
 	"github.com/IvanChernomyrdin/avito-key-generate/runtime/logger"
 )
 
+const (
+	validateModeFormat   = "format"
+	validateModeDatabase = "database"
+
+	// сколько ключей можно проверить по базе за один запрос
+	maxValidateDatabaseKeys = 10000
+)
+
 type Handler struct {
 	db     *sql.DB
 	logger *logger.Logger
+	cfg    *config.Config
+	// будит воркеров фоновой генерации при появлении новой задачи
+	jobWakeup chan struct{}
+	// слова, которые не должны появляться в случайных частях ключей
+	denylist *denylist
+	// ключи подписи групп с подписью, см. signing.go
+	signing *signingKeys
+	// генератор стандартных идентификаторов, см. ids.go
+	ids *ids.Generator
 }
 
-func NewHandler(db *sql.DB, logger *logger.Logger) *Handler {
+func NewHandler(db *sql.DB, logger *logger.Logger, cfg *config.Config) *Handler {
+	deny, err := newDenylist(cfg.DenylistFile)
+	if err != nil {
+		logger.Error("denylist", "Failed to load denylist from "+cfg.DenylistFile, err)
+	}
+	signing, err := newSigningKeys(cfg.SigningKeysFile)
+	if err != nil {
+		logger.Error("signing", "Failed to load signing keys from "+cfg.SigningKeysFile, err)
+	}
+	// узел Snowflake задаётся явно и свой на каждом экземпляре: одинаковый узел на двух
+	// экземплярах дал бы одинаковые идентификаторы, а проверить это сервис не может.
+	// Без узла Snowflake не выпускаются, остальные виды работают
+	idGenerator, err := ids.NewGenerator(cfg.SnowflakeNode)
+	if err != nil {
+		logger.Fatal("ids", "Invalid snowflake node", err)
+	}
+	if !idGenerator.HasNode() {
+		logger.Warn("ids", "Snowflake node is not configured, snowflake identifiers are disabled")
+	}
+
 	return &Handler{
 		db:     db,
 		logger: logger,
+		cfg:    cfg,
+
+		jobWakeup: make(chan struct{}, 1),
+		denylist:  deny,
+		signing:   signing,
+		ids:       idGenerator,
 	}
 }
 
@@ -40,14 +85,30 @@ func (h *Handler) PingDatabaseHandler(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) GetGroupsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+
+	groups, err := h.listGroups()
+	if err != nil {
+		h.logger.Error("handler: GetGroups", "Failed to list groups", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	patterns := make(map[string]string, len(groups))
+	for _, g := range groups {
+		patterns[g.Name] = g.Pattern
+	}
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(patternTemplates)
+	json.NewEncoder(w).Encode(patterns)
 }
 
 func (h *Handler) ValidateKeyHandler(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Group string   `json:"group"`
 		Keys  []string `json:"keys"`
+		// "database" - кроме формата проверить выпуск, группу, статус и срок действия ключа
+		Mode string `json:"mode"`
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -68,77 +129,146 @@ func (h *Handler) ValidateKeyHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "Keys array is empty"})
 		return
 	}
-	pattern, exists := patternTemplates[request.Group]
-	if !exists {
+	if request.Mode == "" {
+		request.Mode = r.URL.Query().Get("mode")
+	}
+	if request.Mode == "" {
+		request.Mode = validateModeFormat
+	}
+	if request.Mode != validateModeFormat && request.Mode != validateModeDatabase {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Mode must be format or database"})
+		return
+	}
+	if request.Mode == validateModeDatabase && len(request.Keys) > maxValidateDatabaseKeys {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Database mode accepts at most %d keys", maxValidateDatabaseKeys)})
+		return
+	}
+	g, err := h.getGroup(request.Group)
+	if errors.Is(err, errGroupNotFound) {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown group"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler", "Database error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	pattern, err := g.keyPattern()
+	if err != nil {
+		h.logger.Error("handler", "Invalid pattern stored for group "+g.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	// ключи приводятся к виду, в котором выпущены: у групп с нормализацией целиком, у
+	// остальных - только регистр символов, которые проверка принимает сверх выпускаемых
+	normalized := make([]string, len(request.Keys))
+	for i, key := range request.Keys {
+		normalized[i] = pattern.NormalizeKey(key)
+	}
+
+	// в режиме database ключ проверяется ещё и по таблице keys
+	var states map[string]*keyState
+	if request.Mode == validateModeDatabase {
+		states, err = h.lookupKeyStates(normalized)
+		if err != nil {
+			h.logger.Error("handler", "Database error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
+	}
+
+	type KeyValidation struct {
+		Key string `json:"key"`
+		// ключ после нормализации, если он отличается от переданного
+		NormalizedKey string `json:"normalized_key,omitempty"`
+		Valid         bool   `json:"valid"`
+		Reason        string `json:"reason"`
 	}
 
 	validKeys := []string{}
 	invalidKeys := []string{}
+	results := make([]KeyValidation, 0, len(request.Keys))
+	now := time.Now()
+
+	for i, key := range request.Keys {
+		canonical := normalized[i]
+		reason := reasonOK
+		if state, found := states[canonical]; found {
+			reason = state.reason(g.Name, now)
+		} else if !h.validKeyFormat(g, pattern, canonical) {
+			reason = reasonBadFormat
+		} else if request.Mode == validateModeDatabase {
+			reason = reasonNotFound
+		}
 
-	for _, key := range request.Keys {
-		if isValidKey(key, pattern) {
+		if reason == reasonOK {
 			validKeys = append(validKeys, key)
 		} else {
 			invalidKeys = append(invalidKeys, key)
 		}
+		result := KeyValidation{Key: key, Valid: reason == reasonOK, Reason: reason}
+		if canonical != key {
+			result.NormalizedKey = canonical
+		}
+		results = append(results, result)
 	}
 
 	type ValidationResponse struct {
-		Group        string   `json:"group"`
-		Pattern      string   `json:"pattern"`
-		TotalCount   int      `json:"total_count"`
-		ValidCount   int      `json:"valid_count"`
-		ValidKeys    []string `json:"valid_keys"`
-		InvalidCount int      `json:"invalid_count"`
-		InvalidKeys  []string `json:"invalid_keys"`
+		Group        string          `json:"group"`
+		Pattern      string          `json:"pattern"`
+		Mode         string          `json:"mode"`
+		TotalCount   int             `json:"total_count"`
+		ValidCount   int             `json:"valid_count"`
+		ValidKeys    []string        `json:"valid_keys"`
+		InvalidCount int             `json:"invalid_count"`
+		InvalidKeys  []string        `json:"invalid_keys"`
+		Results      []KeyValidation `json:"results"`
 	}
 
 	response := &ValidationResponse{
 		Group:        request.Group,
-		Pattern:      pattern,
+		Pattern:      pattern.Source,
+		Mode:         request.Mode,
 		TotalCount:   len(request.Keys),
 		ValidCount:   len(validKeys),
 		ValidKeys:    validKeys,
 		InvalidCount: len(invalidKeys),
 		InvalidKeys:  invalidKeys,
+		Results:      results,
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
-func isValidKey(key, pattern string) bool {
-	if len(key) != len(pattern) {
-		return false
-	}
-
-	for i, char := range key {
-		patternChar := pattern[i]
-
-		switch patternChar {
-		case 'X':
-			if !isAlphaNumeric(byte(char)) {
-				return false
-			}
-		default:
-			if byte(char) != patternChar {
-				return false
-			}
-		}
-	}
-	return true
-}
-
-func isAlphaNumeric(char byte) bool {
-	return unicode.IsLetter(rune(char)) || unicode.IsNumber(rune(char))
-}
-
 func (h *Handler) GenerateKeysHandler(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Group string
 		Count int
+		// окно действия ключей: явные границы или TTL от начала действия
+		ValidFrom  *time.Time `json:"valid_from"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		TTLSeconds int64      `json:"ttl_seconds"`
+		// лимиты использования, по умолчанию берутся из группы
+		MaxUses           *int `json:"max_uses"`
+		MaxUsesPerSubject *int `json:"max_uses_per_subject"`
+		// метаданные каждого ключа, дополняют метаданные группы
+		Metadata json.RawMessage `json:"metadata"`
+		// партия, в которую попадут ключи; имя по умолчанию - группа и время
+		Batch         string          `json:"batch"`
+		CreatedBy     string          `json:"created_by"`
+		BatchMetadata json.RawMessage `json:"batch_metadata"`
+		// детерминированная генерация для фикстур (только с админским токеном), см. seed.go
+		Seed string `json:"seed"`
+		// вернуть ключи, ничего не сохраняя
+		DryRun bool `json:"dry_run"`
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -151,89 +281,256 @@ func (h *Handler) GenerateKeysHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "Group is required"})
 		return
 	}
-	if request.Count == 0 {
+	if request.Count <= 0 {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Count can't be empty or equal to 0"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "Count can't be empty or less than 1"})
 		return
 	}
 
-	pattern, exists := patternTemplates[request.Group]
-	if !exists {
+	g, err := h.getGroup(request.Group)
+	if errors.Is(err, errGroupNotFound) {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown group"})
 		return
 	}
+	if err != nil {
+		h.logger.Error("handler", "Database error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	pattern, err := g.keyPattern()
+	if err != nil {
+		h.logger.Error("handler", "Invalid pattern stored for group "+g.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	if err := g.checkEntropy(pattern); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group pattern is too weak: " + err.Error()})
+		return
+	}
+	if pattern.Kind == ids.Snowflake && !h.ids.HasNode() {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Snowflake node is not configured"})
+		return
+	}
+
+	if request.Seed != "" {
+		if !h.isAdmin(r) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Seeded generation requires the admin token"})
+			return
+		}
+		if pattern.Kind != "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Seeded generation is not supported for groups with kind"})
+			return
+		}
+	}
+	// пробная генерация permuted показывает следующие настоящие ключи группы
+	if request.DryRun && request.Seed == "" && pattern.Permutation != nil && !h.isAdmin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Dry-run for permuted groups requires the admin token"})
+		return
+	}
+	// генерация с seed и пробная генерация всегда синхронные
+	if (request.Seed != "" || request.DryRun) && h.cfg.AsyncGenerateThreshold > 0 && request.Count > h.cfg.AsyncGenerateThreshold {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Seeded and dry-run generation are limited to %d keys", h.cfg.AsyncGenerateThreshold)})
+		return
+	}
 
-	// сгенерированные ключи и хэштаблица их для проверки что уже такой был
-	generateKeys := make([]string, 0, request.Count)
-	usedKeys := make(map[string]bool)
+	now := time.Now()
+	if errors.Is(g.window().check(now), errKeyExpired) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group has expired"})
+		return
+	}
+	window, err := keyWindowFor(g, request.ValidFrom, request.ExpiresAt, request.TTLSeconds, now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid validity window: " + err.Error()})
+		return
+	}
 
-	for len(generateKeys) < request.Count {
-		key := generateKey(pattern)
+	opts := keyInsertOptions{
+		window:            window,
+		maxUses:           g.MaxUses,
+		maxUsesPerSubject: g.MaxUsesPerSubject,
+	}
+	if request.MaxUses != nil {
+		opts.maxUses = *request.MaxUses
+	}
+	if request.MaxUsesPerSubject != nil {
+		opts.maxUsesPerSubject = *request.MaxUsesPerSubject
+	}
+	if err := validateUsageLimits(opts.maxUses, opts.maxUsesPerSubject); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid usage limits: " + err.Error()})
+		return
+	}
+	opts.metadata, err = mergeMetadata(g.Metadata, request.Metadata)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid metadata: " + err.Error()})
+		return
+	}
 
-		if !usedKeys[key] {
-			exists, err := h.checkKeyExists(key)
-			if err != nil {
-				h.logger.Error("handler", "Database error", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
-			}
-			if !exists {
-				if err := h.saveKeyToDB(key, request.Group, pattern); err != nil {
-					h.logger.Error("handler", "Failed to save key", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save key"})
-				}
+	capacity := h.capacityOf(g, pattern)
+	if int64(request.Count) > capacity.Available {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{"error": "Group capacity exceeded", "capacity": capacity})
+		return
+	}
+	opts.capacityLimit = capacity.limit
+	opts.seed = request.Seed
 
-				generateKeys = append(generateKeys, key)
-				usedKeys[key] = true
-			}
+	b, err := newBatch(g, request.Count, request.Batch, request.CreatedBy, request.BatchMetadata, now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid batch: " + err.Error()})
+		return
+	}
+
+	// ответ с Idempotency-Key хранится и повторяется, а ключи секретной группы
+	// показываются ровно один раз
+	if g.Secret && r.Header.Get(idempotencyHeader) != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Idempotency-Key is not supported for secret groups"})
+		return
+	}
+	if g.Secret && h.cfg.KeyPepper == "" {
+		h.logger.Error("handler", "Key pepper is not configured for secret group "+g.Name, nil)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Secret groups are not configured"})
+		return
+	}
+	if g.Signature != "" {
+		if _, err := h.signingKey(g); err != nil {
+			h.logger.Error("handler", "Signing key is not configured for group "+g.Name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Signing key is not configured for group"})
+			return
 		}
 	}
 
 	type GenerateKey struct {
 		Group          string   `json:"group"`
+		BatchID        int64    `json:"batch_id,omitempty"`
 		Pattern        string   `json:"pattern"`
 		Generate_count int      `json:"count"`
 		Keys           []string `json:"keys"`
+		// ключи секретной группы не сохраняются и больше нигде не будут показаны
+		Secret bool `json:"secret,omitempty"`
+		// ключи пробной генерации не записаны в базу
+		DryRun bool `json:"dry_run,omitempty"`
+		validityWindow
 	}
+
+	if request.DryRun {
+		previewKeys, err := h.dryRunKeys(g, pattern, request.Count, request.Seed)
+		if errors.Is(err, keygen.ErrKeyspaceExhausted) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Group keyspace is exhausted"})
+			return
+		}
+		if err != nil {
+			h.logger.Error("handler", "Failed to generate dry-run keys", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&GenerateKey{
+			Group:          request.Group,
+			Pattern:        pattern.Source,
+			Generate_count: len(previewKeys),
+			Keys:           previewKeys,
+			DryRun:         true,
+			validityWindow: window,
+		})
+		return
+	}
+
+	// большие генерации не держат HTTP-запрос, а уходят в фоновую задачу
+	if request.Seed == "" && h.cfg.AsyncGenerateThreshold > 0 && request.Count > h.cfg.AsyncGenerateThreshold {
+		if g.Secret {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Keys of secret groups are shown only once and can't be generated in batches over %d", h.cfg.AsyncGenerateThreshold)})
+			return
+		}
+		j, err := h.enqueueGenerateJob(b, opts)
+		if errors.Is(err, errCapacityExceeded) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Group capacity exceeded"})
+			return
+		}
+		if err != nil {
+			h.logger.Error("handler", "Failed to create generation job", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", j.ID))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(j)
+		return
+	}
+
+	generateKeys, err := h.generateAndInsertKeys(g, pattern, b, keygen.NewCryptoSource(), opts)
+	if errors.Is(err, keygen.ErrKeyspaceExhausted) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group keyspace is exhausted"})
+		return
+	}
+	if errors.Is(err, errCapacityExceeded) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group capacity exceeded"})
+		return
+	}
+	if errors.Is(err, errSeededKeysExist) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Keys for this seed already exist"})
+		return
+	}
+	if errors.Is(err, errSeededKeyDenied) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Keys for this seed contain denylisted words, use another seed"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler", "Failed to save keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save keys"})
+		return
+	}
+
 	response := &GenerateKey{
 		Group:          request.Group,
-		Pattern:        pattern,
+		BatchID:        b.ID,
+		Pattern:        pattern.Source,
 		Generate_count: len(generateKeys),
 		Keys:           generateKeys,
+		Secret:         g.Secret,
+		validityWindow: window,
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
-func generateKey(pattern string) string {
-	key := make([]byte, len(pattern))
-
-	for i := 0; i < len(pattern); i++ {
-		switch pattern[i] {
-		case 'X':
-			key[i] = randomAlphaNumeric()
-		default:
-			key[i] = pattern[i]
+// generateKey выпускает ключ по шаблону, перетягивая кандидатов со словами из стоп-листа.
+// false означает, что за maxDenylistRedraws попыток приличного ключа не нашлось.
+func generateKey(pattern *keygen.Pattern, source *keygen.Source, deny *denylist) (string, bool) {
+	for attempt := 0; attempt < maxDenylistRedraws; attempt++ {
+		key := pattern.Draw(source)
+		if deny.allows(key, pattern) {
+			return key, true
 		}
+		deny.rejected.Add(1)
 	}
-	return string(key)
-}
-
-func randomAlphaNumeric() byte {
-	chars := "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	return chars[rand.Intn(len(chars))]
-}
-
-func (h *Handler) checkKeyExists(key string) (bool, error) {
-	var exists bool
-	err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM keys WHERE key_value = $1 AND status = TRUE)", key).Scan(&exists)
-	return exists, err
-}
-
-func (h *Handler) saveKeyToDB(key, group, pattern string) error {
-	_, err := h.db.Exec("INSERT INTO keys (key_value, group_name, pattern, status, created_at) VALUES ($1, $2, $3, $4, $5)", key, group, pattern, "TRUE", time.Now())
-	return err
+	return "", false
 }
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Запросы с заголовком Idempotency-Key выполняются один раз: перед запуском
+// обработчика ключ занимается строкой в idempotency_keys, а после сохраняется
+// ответ. Повтор с тем же ключом и тем же запросом получает сохранённый ответ,
+// пока не истёк IdempotencyTTL; тот же ключ с другим запросом - 422.
+//
+// Ключи принадлежат вызывающему (см. idempotencyScope): чужой клиент с тем же
+// ключом и телом не получит сохранённый ответ. Ключи секретных групп в базу не
+// попадают: генерация для них отклоняет Idempotency-Key, а погашение, отзыв и
+// восстановление сохраняют ответ с префиксом вместо ключа (см. redactIdempotentKey),
+// так что повтор возвращает тот же статус, но без самого ключа.
+
+const (
+	idempotencyHeader         = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+
+	// запрос, не сохранивший ответ за это время, считается упавшим,
+	// и его ключ может занять повтор
+	idempotencyLockTimeout = 5 * time.Minute
+)
+
+var (
+	errIdempotencyMismatch   = errors.New("idempotency key reused with a different request")
+	errIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// заголовки ответа, которые повторяются вместе с телом
+var idempotentResponseHeaders = []string{"Content-Type", "Location"}
+
+// keyRedaction - ключ, который в сохранённом ответе заменяется на replacement
+type keyRedaction struct {
+	key         string
+	replacement string
+}
+
+type idempotencyRedactionsKey struct{}
+
+// storedResponse - сохранённый ответ на запрос с Idempotency-Key
+type storedResponse struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       []byte
+}
+
+// bufferedResponse пишет ответ клиенту и одновременно запоминает его целиком
+type bufferedResponse struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	b.statusCode = code
+	b.ResponseWriter.WriteHeader(code)
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.body.Write(p)
+	return b.ResponseWriter.Write(p)
+}
+
+func (h *Handler) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if len(key) > maxIdempotencyKeyLength {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength)})
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to read request body"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(r)
+		stored, err := h.acquireIdempotencyKey(scope, key, requestFingerprint(r, body))
+		switch {
+		case errors.Is(err, errIdempotencyMismatch):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Idempotency-Key was already used with a different request"})
+			return
+		case errors.Is(err, errIdempotencyInProgress):
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "A request with this Idempotency-Key is still in progress"})
+			return
+		case err != nil:
+			h.logger.Error("handler: Idempotency", "Failed to acquire idempotency key", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
+
+		if stored != nil {
+			for name, value := range stored.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set(idempotencyReplayedHeader, "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			return
+		}
+
+		redactions := &[]keyRedaction{}
+		r = r.WithContext(context.WithValue(r.Context(), idempotencyRedactionsKey{}, redactions))
+		response := &bufferedResponse{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(response, r)
+
+		// после 5xx ключ освобождается, чтобы клиент мог повторить запрос
+		if response.statusCode >= http.StatusInternalServerError {
+			err = h.releaseIdempotencyKey(scope, key)
+		} else {
+			err = h.saveIdempotentResponse(scope, key, response, *redactions)
+		}
+		if err != nil {
+			h.logger.Error("handler: Idempotency", "Failed to store idempotent response", err)
+		}
+	})
+}
+
+// redactIdempotentKey не даёт сохранить ключ секретной группы вместе с ответом на
+// запрос с Idempotency-Key: клиент получает ответ как есть, а в базу (и в повторы)
+// попадает prefix с многоточием. Без Idempotency-Key ничего не делает.
+func redactIdempotentKey(r *http.Request, key, prefix string) {
+	redactions, ok := r.Context().Value(idempotencyRedactionsKey{}).(*[]keyRedaction)
+	if !ok {
+		return
+	}
+	*redactions = append(*redactions, keyRedaction{key: key, replacement: prefix + "…"})
+}
+
+// redactResponseBody заменяет строки JSON, равные ключу целиком; части других строк не трогаются
+func redactResponseBody(body []byte, redactions []keyRedaction) []byte {
+	for _, redaction := range redactions {
+		key, err := json.Marshal(redaction.key)
+		if err != nil {
+			continue
+		}
+		replacement, err := json.Marshal(redaction.replacement)
+		if err != nil {
+			continue
+		}
+		body = bytes.ReplaceAll(body, key, replacement)
+	}
+	return body
+}
+
+// idempotencyScope - хеш учётных данных вызывающего. Сервис сам не проверяет
+// Authorization (это делает шлюз перед ним), но разные клиенты присылают разные
+// заголовки, поэтому их ключи идемпотентности не пересекаются. Админский токен тоже
+// входит в scope: без него нельзя получить сохранённый ответ админского запроса.
+func idempotencyScope(r *http.Request) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "authorization:%s\n", r.Header.Get("Authorization"))
+	fmt.Fprintf(hash, "admin-token:%s\n", r.Header.Get(adminTokenHeader))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// requestFingerprint - отпечаток запроса: метод, путь, query и тело
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// acquireIdempotencyKey занимает ключ под новый запрос. Если ключ уже выполнен
+// тем же запросом, возвращается сохранённый ответ.
+func (h *Handler) acquireIdempotencyKey(scope, key, fingerprint string) (*storedResponse, error) {
+	now := time.Now()
+	for {
+		result, err := h.db.Exec(`INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT (scope, idempotency_key) DO NOTHING`,
+			scope, key, fingerprint, now, now.Add(h.cfg.IdempotencyTTL))
+		if err != nil {
+			return nil, err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if inserted == 1 {
+			return nil, nil
+		}
+
+		var (
+			storedFingerprint string
+			statusCode        sql.NullInt64
+			headers           []byte
+			stored            = &storedResponse{}
+			createdAt         time.Time
+			expiresAt         time.Time
+		)
+		err = h.db.QueryRow(`SELECT fingerprint, status_code, response_headers, response_body, created_at, expires_at
+			FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2`, scope, key).
+			Scan(&storedFingerprint, &statusCode, &headers, &stored.Body, &createdAt, &expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			// ключ удалили между вставкой и чтением - пробуем занять снова
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !expiresAt.After(now) {
+			// окно повтора истекло, ключ можно использовать заново
+			if _, err := h.db.Exec("DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2 AND expires_at <= $3", scope, key, now); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if storedFingerprint != fingerprint {
+			return nil, errIdempotencyMismatch
+		}
+		if !statusCode.Valid {
+			return nil, h.takeOverIdempotencyKey(scope, key, now)
+		}
+
+		stored.StatusCode = int(statusCode.Int64)
+		if len(headers) > 0 {
+			if err := json.Unmarshal(headers, &stored.Headers); err != nil {
+				return nil, err
+			}
+		}
+		return stored, nil
+	}
+}
+
+// takeOverIdempotencyKey забирает ключ у запроса, который так и не сохранил ответ
+func (h *Handler) takeOverIdempotencyKey(scope, key string, now time.Time) error {
+	result, err := h.db.Exec(`UPDATE idempotency_keys SET created_at = $3
+		WHERE scope = $1 AND idempotency_key = $2 AND status_code IS NULL AND created_at < $4`,
+		scope, key, now, now.Add(-idempotencyLockTimeout))
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errIdempotencyInProgress
+	}
+	return nil
+}
+
+func (h *Handler) saveIdempotentResponse(scope, key string, response *bufferedResponse, redactions []keyRedaction) error {
+	headers := make(map[string]string, len(idempotentResponseHeaders))
+	for _, name := range idempotentResponseHeaders {
+		if value := response.Header().Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.Exec(`UPDATE idempotency_keys SET status_code = $3, response_headers = $4, response_body = $5
+		WHERE scope = $1 AND idempotency_key = $2`, scope, key, response.statusCode, string(encoded),
+		redactResponseBody(response.body.Bytes(), redactions))
+	return err
+}
+
+func (h *Handler) releaseIdempotencyKey(scope, key string) error {
+	_, err := h.db.Exec("DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2", scope, key)
+	return err
+}
+
+// sweepIdempotencyKeys удаляет ключи с истёкшим окном повтора
+func (h *Handler) sweepIdempotencyKeys(ctx context.Context) {
+	result, err := h.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= NOW()")
+	if err != nil {
+		if ctx.Err() == nil {
+			h.logger.Error("idempotency", "Failed to sweep idempotency keys", err)
+		}
+		return
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted > 0 {
+		h.logger.Info("idempotency", fmt.Sprintf("Deleted %d expired idempotency keys", deleted))
+	}
+}
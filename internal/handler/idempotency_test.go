@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactIdempotentKeyWithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/keys/redeem", nil)
+	// без Idempotency-Key в контексте нет списка, вызов ничего не делает
+	redactIdempotentKey(r, "AVITO-SECRET-KEY", "AVITO-S")
+}
+
+func TestRedactedResponseHasNoSecretKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		body any
+	}{
+		{
+			name: "redeem",
+			key:  "AVITO-Q7XK-3MZP",
+			body: &redeemedKey{Key: "AVITO-Q7XK-3MZP", Group: "api_key", RedeemedBy: "user-1", UseCount: 1, MaxUses: 1},
+		},
+		{
+			name: "revoke",
+			key:  "AVITO-Q7XK-3MZP",
+			body: map[string]string{"key": "AVITO-Q7XK-3MZP", "status": keyStatusRevoked},
+		},
+		{
+			// json.Encoder экранирует <, > и &, ключ должен находиться и в таком виде
+			name: "escaped characters",
+			key:  "K<&>9",
+			body: map[string]string{"key": "K<&>9", "status": keyStatusActive},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactions := &[]keyRedaction{}
+			r := httptest.NewRequest("POST", "/api/keys/redeem", nil)
+			r = r.WithContext(context.WithValue(r.Context(), idempotencyRedactionsKey{}, redactions))
+			redactIdempotentKey(r, tt.key, tt.key[:3])
+
+			var body bytes.Buffer
+			if err := json.NewEncoder(&body).Encode(tt.body); err != nil {
+				t.Fatal(err)
+			}
+			stored := redactResponseBody(body.Bytes(), *redactions)
+
+			var decoded map[string]any
+			if err := json.Unmarshal(stored, &decoded); err != nil {
+				t.Fatalf("stored body is not JSON: %v (%s)", err, stored)
+			}
+			if decoded["key"] != tt.key[:3]+"…" {
+				t.Errorf("stored key = %v, want %q", decoded["key"], tt.key[:3]+"…")
+			}
+			if strings.Contains(string(stored), tt.key) {
+				t.Errorf("stored body %s contains the secret key", stored)
+			}
+		})
+	}
+}
+
+func TestRedactResponseBodyMatchesWholeStrings(t *testing.T) {
+	body := []byte(`{"key":"ABC","group":"ABCD","note":"xABC"}` + "\n")
+	stored := redactResponseBody(body, []keyRedaction{{key: "ABC", replacement: "A…"}})
+	want := `{"key":"A…","group":"ABCD","note":"xABC"}` + "\n"
+	if string(stored) != want {
+		t.Errorf("redactResponseBody = %s, want %s", stored, want)
+	}
+}
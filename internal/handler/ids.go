@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/ids"
+	"github.com/go-chi/chi/v5"
+)
+
+// Кроме ключей по шаблону сервис выпускает стандартные идентификаторы (см. pkg/ids):
+// разово через /api/ids/{kind} без сохранения или как ключи группы с полем kind,
+// которые хранятся, проверяются и гасятся так же, как ключи по шаблону.
+
+// сколько идентификаторов можно получить одним запросом /api/ids/{kind}
+const maxIDsPerRequest = 10000
+
+var errKindSettings = errors.New("groups with kind can't have pattern, checksum, alphabet, normalize or permuted generation")
+
+// nextKey выпускает кандидата в ключи: стандартный идентификатор для групп с kind,
+// иначе ключ по шаблону с проверкой по стоп-листу
+func (h *Handler) nextKey(pattern *keygen.Pattern, source *keygen.Source) (string, bool) {
+	if pattern.Kind == "" {
+		return generateKey(pattern, source, h.denylist)
+	}
+	id, err := h.ids.New(pattern.Kind)
+	return id, err == nil
+}
+
+// GenerateIDsHandler выдаёт count идентификаторов вида kind, ничего не сохраняя
+func (h *Handler) GenerateIDsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	kind := chi.URLParam(r, "kind")
+	info, ok := ids.Lookup(kind)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"error": "Unknown id kind", "kinds": ids.Kinds()})
+		return
+	}
+
+	count := 1
+	if value := r.URL.Query().Get("count"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxIDsPerRequest {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Count must be between 1 and %d", maxIDsPerRequest)})
+			return
+		}
+		count = parsed
+	}
+
+	generated := make([]string, count)
+	for i := range generated {
+		id, err := h.ids.New(kind)
+		if errors.Is(err, ids.ErrNoNode) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Snowflake node is not configured"})
+			return
+		}
+		if err != nil {
+			h.logger.Error("handler: GenerateIDs", "Failed to generate "+kind, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
+		generated[i] = id
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"kind": info.Kind, "count": count, "ids": generated})
+}
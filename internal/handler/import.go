@@ -0,0 +1,408 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// Импорт регистрирует ключи, выпущенные вне сервиса (например, напечатанные партнёром).
+// Строки файла проверяются по шаблону группы и потоком через COPY ложатся во временную
+// таблицу, откуда одним INSERT ... ON CONFLICT DO NOTHING переносятся в keys. Ключи,
+// которые уже есть в базе или повторяются в файле, попадают в отчёт построчно.
+
+const (
+	importReasonBadRow        = "bad_row"
+	importReasonDuplicate     = "duplicate"
+	importReasonDuplicateFile = "duplicate_in_file"
+
+	// сколько ошибочных строк перечисляется в ответе, остальные только считаются
+	maxImportReportedErrors = 1000
+	// максимальная длина строки NDJSON
+	maxImportLineSize = 1 << 20
+)
+
+// importRowError - строка файла, которая не попала в keys
+type importRowError struct {
+	Row    int    `json:"row"`
+	Key    string `json:"key,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// importReport - итог импорта
+type importReport struct {
+	Group           string           `json:"group"`
+	BatchID         int64            `json:"batch_id"`
+	TotalRows       int              `json:"total_rows"`
+	Imported        int              `json:"imported"`
+	Rejected        int              `json:"rejected"`
+	Errors          []importRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated,omitempty"`
+}
+
+func (r *importReport) reject(row int, key, reason string) {
+	r.Rejected++
+	if len(r.Errors) < maxImportReportedErrors {
+		r.Errors = append(r.Errors, importRowError{Row: row, Key: key, Reason: reason})
+	} else {
+		r.ErrorsTruncated = true
+	}
+}
+
+// importRow - одна строка файла: ключ и необязательные метаданные (только NDJSON)
+type importRow struct {
+	Key      string          `json:"key"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// importReader читает строки файла. Ошибка формата одной строки возвращается как
+// errBadImportRow и не прерывает чтение.
+type importReader interface {
+	next() (*importRow, error)
+}
+
+var errBadImportRow = errors.New("bad import row")
+
+type csvImportReader struct {
+	csv   *csv.Reader
+	first bool
+}
+
+func (c *csvImportReader) next() (*importRow, error) {
+	for {
+		record, err := c.csv.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			c.first = false
+			return nil, errBadImportRow
+		}
+		if err != nil {
+			return nil, err
+		}
+		key := strings.TrimSpace(record[0])
+		// первая строка со словом key - заголовок
+		if c.first {
+			c.first = false
+			if strings.EqualFold(key, "key") {
+				continue
+			}
+		}
+		return &importRow{Key: key}, nil
+	}
+}
+
+type ndjsonImportReader struct {
+	lines *bufio.Scanner
+}
+
+func (n *ndjsonImportReader) next() (*importRow, error) {
+	for n.lines.Scan() {
+		line := strings.TrimSpace(n.lines.Text())
+		if line == "" {
+			continue
+		}
+		row := &importRow{}
+		if err := json.Unmarshal([]byte(line), row); err != nil {
+			return nil, errBadImportRow
+		}
+		return row, nil
+	}
+	if err := n.lines.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func newImportReader(body io.Reader, format string) importReader {
+	if format == exportFormatNDJSON {
+		lines := bufio.NewScanner(body)
+		lines.Buffer(make([]byte, 64*1024), maxImportLineSize)
+		return &ndjsonImportReader{lines: lines}
+	}
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return &csvImportReader{csv: reader, first: true}
+}
+
+// importSource отдаёт COPY проверенные строки файла, отбракованные пишет в отчёт
+type importSource struct {
+	h       *Handler
+	reader  importReader
+	group   *group
+	pattern *keygen.Pattern
+	report  *importReport
+	values  []any
+	err     error
+}
+
+func (s *importSource) Next() bool {
+	for {
+		row, err := s.reader.next()
+		if errors.Is(err, io.EOF) {
+			return false
+		}
+		s.report.TotalRows++
+		rowNum := s.report.TotalRows
+		if errors.Is(err, errBadImportRow) {
+			s.report.reject(rowNum, "", importReasonBadRow)
+			continue
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		row.Key = s.pattern.NormalizeKey(row.Key)
+		if !s.h.validKeyFormat(s.group, s.pattern, row.Key) {
+			s.report.reject(rowNum, row.Key, reasonBadFormat)
+			continue
+		}
+		merged, err := mergeMetadata(s.group.Metadata, row.Metadata)
+		if err != nil {
+			s.report.reject(rowNum, row.Key, importReasonBadRow)
+			continue
+		}
+		metadata, _ := jsonObject(merged)
+
+		var hash, prefix, base any
+		if s.group.Secret {
+			hash = s.h.hashKey(row.Key).String
+			prefix = displayPrefix(row.Key, s.pattern)
+		}
+		if value := s.h.keyBase(s.group, row.Key); value.Valid {
+			base = value.String
+		}
+		s.values = []any{rowNum, row.Key, hash, prefix, base, metadata}
+		return true
+	}
+}
+
+func (s *importSource) Values() ([]any, error) {
+	return s.values, nil
+}
+
+func (s *importSource) Err() error {
+	return s.err
+}
+
+// ImportKeysHandler принимает файл в теле запроса: ?group=&format=csv|ndjson, а также
+// необязательные batch и created_by. Формат по умолчанию берётся из Content-Type.
+// В CSV ключ - первая колонка, в NDJSON - объект {"key": "...", "metadata": {...}}.
+func (h *Handler) ImportKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = exportFormatCSV
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil &&
+			(mediaType == "application/x-ndjson" || mediaType == "application/ndjson") {
+			format = exportFormatNDJSON
+		}
+	}
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Format must be csv or ndjson"})
+		return
+	}
+
+	g, err := h.getGroup(query.Get("group"))
+	if errors.Is(err, errGroupNotFound) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown group"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler: ImportKeys", "Database error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	pattern, err := g.keyPattern()
+	if err != nil {
+		h.logger.Error("handler: ImportKeys", "Invalid pattern stored for group "+g.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	if g.Secret && h.cfg.KeyPepper == "" {
+		h.logger.Error("handler: ImportKeys", "Key pepper is not configured for secret group "+g.Name, nil)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Secret groups are not configured"})
+		return
+	}
+
+	now := time.Now()
+	if errors.Is(g.window().check(now), errKeyExpired) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Group has expired"})
+		return
+	}
+	window, err := keyWindowFor(g, nil, nil, 0, now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid validity window: " + err.Error()})
+		return
+	}
+	b, err := newBatch(g, 0, query.Get("batch"), query.Get("created_by"), nil, now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid batch: " + err.Error()})
+		return
+	}
+
+	source := &importSource{
+		h:       h,
+		reader:  newImportReader(r.Body, format),
+		group:   g,
+		pattern: pattern,
+		report:  &importReport{Group: g.Name, Errors: []importRowError{}},
+	}
+	opts := keyInsertOptions{window: window, maxUses: g.MaxUses, maxUsesPerSubject: g.MaxUsesPerSubject}
+	if err := h.importKeys(r.Context(), source, b, opts); err != nil {
+		h.logger.Error("handler: ImportKeys", "Failed to import keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(source.report)
+}
+
+// importKeys выполняет импорт в одной транзакции. COPY доступен только через pgx,
+// поэтому транзакция открывается на «сыром» соединении пула.
+func (h *Handler) importKeys(ctx context.Context, source *importSource, b *batch, opts keyInsertOptions) error {
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		tx, err := pgConn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		err = tx.QueryRow(ctx, `INSERT INTO batches (name, group_name, requested_count, created_by, created_at)
+			VALUES ($1, $2, 0, NULLIF($3, ''), $4) RETURNING id`,
+			b.Name, b.Group, b.CreatedBy, b.CreatedAt).Scan(&b.ID)
+		if err != nil {
+			return err
+		}
+		source.report.BatchID = b.ID
+
+		_, err = tx.Exec(ctx, `CREATE TEMP TABLE import_keys (
+			row_num INTEGER NOT NULL,
+			key_value TEXT NOT NULL,
+			key_hash TEXT,
+			key_prefix TEXT,
+			key_base TEXT,
+			metadata JSONB
+		) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"import_keys"},
+			[]string{"row_num", "key_value", "key_hash", "key_prefix", "key_base", "metadata"}, source); err != nil {
+			return err
+		}
+
+		// первое вхождение каждого ключа в файле пробуем вставить, остальные строки -
+		// повторы внутри файла; первые вхождения, не попавшие в keys, уже есть в базе
+		columns, values := "key_value, key_base", "f.key_value, f.key_base"
+		if source.group.Secret {
+			columns, values = "key_hash, key_prefix, key_base", "f.key_hash, f.key_prefix, f.key_base"
+		}
+		rows, err := tx.Query(ctx, fmt.Sprintf(`WITH first AS (
+				SELECT DISTINCT ON (key_value) * FROM import_keys ORDER BY key_value, row_num
+			), inserted AS (
+				INSERT INTO keys (%s, group_name, pattern, status, created_at, valid_from, expires_at,
+					max_uses, max_uses_per_subject, batch_id, metadata)
+				SELECT %s, $1, $2, 'active', $3, $4, $5, $6, $7, $8, f.metadata FROM first f ORDER BY f.row_num
+				ON CONFLICT DO NOTHING
+				RETURNING COALESCE(key_hash, key_value) AS stored
+			)
+			SELECT i.row_num, i.key_value, f.row_num IS NULL FROM import_keys i
+			LEFT JOIN first f ON f.row_num = i.row_num
+			WHERE f.row_num IS NULL
+				OR NOT EXISTS (SELECT 1 FROM inserted n WHERE n.stored = COALESCE(f.key_hash, f.key_value))
+			ORDER BY i.row_num`, columns, values),
+			b.Group, source.pattern.Source, b.CreatedAt, nullTime(opts.window.ValidFrom), nullTime(opts.window.ExpiresAt),
+			opts.maxUses, opts.maxUsesPerSubject, b.ID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		// строки отчёта копятся отдельно и сливаются с ошибками разбора по номеру строки
+		duplicates := []importRowError{}
+		rejected := 0
+		for rows.Next() {
+			var (
+				row    importRowError
+				inFile bool
+			)
+			if err := rows.Scan(&row.Row, &row.Key, &inFile); err != nil {
+				return err
+			}
+			row.Reason = importReasonDuplicate
+			if inFile {
+				row.Reason = importReasonDuplicateFile
+			}
+			rejected++
+			if len(duplicates) < maxImportReportedErrors {
+				duplicates = append(duplicates, row)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		source.report.mergeDuplicates(duplicates, rejected)
+
+		report := source.report
+		report.Imported = report.TotalRows - report.Rejected
+		if _, err := tx.Exec(ctx, "UPDATE batches SET requested_count = $2 WHERE id = $1", b.ID, report.Imported); err != nil {
+			return err
+		}
+		// импорт не ограничивается ёмкостью, но занимает место в пространстве ключей
+		if _, err := tx.Exec(ctx, "UPDATE groups SET issued_count = issued_count + $2 WHERE name = $1", b.Group, report.Imported); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// mergeDuplicates добавляет в отчёт повторы, найденные базой, сохраняя порядок строк
+func (r *importReport) mergeDuplicates(duplicates []importRowError, total int) {
+	merged := make([]importRowError, 0, min(len(r.Errors)+len(duplicates), maxImportReportedErrors))
+	i, j := 0, 0
+	for len(merged) < maxImportReportedErrors && (i < len(r.Errors) || j < len(duplicates)) {
+		if j >= len(duplicates) || (i < len(r.Errors) && r.Errors[i].Row < duplicates[j].Row) {
+			merged = append(merged, r.Errors[i])
+			i++
+		} else {
+			merged = append(merged, duplicates[j])
+			j++
+		}
+	}
+	r.Rejected += total
+	r.ErrorsTruncated = r.ErrorsTruncated || r.Rejected > len(merged)
+	r.Errors = merged
+}
@@ -0,0 +1,483 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/go-chi/chi/v5"
+)
+
+// Большие генерации выполняются фоновыми задачами. Задача пишет ключи порциями по
+// jobChunkSize, и каждая порция коммитится вместе с generated_count, поэтому после
+// рестарта задача продолжает ровно с того места, где остановилась.
+// Воркер, взявший задачу, обновляет heartbeat_at; задачу с протухшим heartbeat
+// (упавший экземпляр) может забрать любой другой воркер. При захвате задача получает
+// новый lease_token, и порция коммитится, только если токен всё ещё её: зависший,
+// но живой воркер не допишет ключи в задачу, которую уже забрали.
+
+const (
+	jobStatusPending = "pending"
+	jobStatusRunning = "running"
+	jobStatusDone    = "done"
+	jobStatusFailed  = "failed"
+
+	jobChunkSize    = 10000
+	jobLeaseTimeout = 2 * time.Minute
+	// heartbeat идёт отдельно от порций, поэтому медленная порция не теряет аренду
+	jobHeartbeatInterval = jobLeaseTimeout / 4
+	jobPollInterval      = 2 * time.Second
+	defaultPageLimit     = 1000
+	maxPageLimit         = 10000
+)
+
+var (
+	errJobNotFound  = errors.New("job not found")
+	errJobLeaseLost = errors.New("job lease lost")
+)
+
+type job struct {
+	ID             int64  `json:"id"`
+	Group          string `json:"group"`
+	RequestedCount int    `json:"requested_count"`
+	GeneratedCount int    `json:"generated_count"`
+	Status         string `json:"status"`
+	Error          string `json:"error,omitempty"`
+	validityWindow
+	MaxUses           int    `json:"max_uses"`
+	MaxUsesPerSubject int    `json:"max_uses_per_subject"`
+	BatchID           *int64 `json:"batch_id,omitempty"`
+	// метаданные, которые получит каждый ключ задачи
+	Metadata   json.RawMessage `json:"metadata,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+
+	// токен аренды воркера, который выполняет задачу
+	leaseToken string
+}
+
+const jobColumns = "id, group_name, requested_count, generated_count, status, COALESCE(error, ''), valid_from, expires_at, max_uses, max_uses_per_subject, batch_id, key_metadata, created_at, updated_at, finished_at"
+
+func scanJob(row rowScanner) (*job, error) {
+	j := &job{}
+	var (
+		validFrom, expiresAt, finishedAt sql.NullTime
+		batchID                          sql.NullInt64
+		metadata                         []byte
+	)
+	err := row.Scan(&j.ID, &j.Group, &j.RequestedCount, &j.GeneratedCount, &j.Status, &j.Error,
+		&validFrom, &expiresAt, &j.MaxUses, &j.MaxUsesPerSubject, &batchID, &metadata, &j.CreatedAt, &j.UpdatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.ValidFrom = timePtr(validFrom)
+	j.ExpiresAt = timePtr(expiresAt)
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	if batchID.Valid {
+		j.BatchID = &batchID.Int64
+	}
+	if metadata != nil {
+		j.Metadata = metadata
+	}
+	return j, nil
+}
+
+func (h *Handler) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid job id"})
+		return
+	}
+
+	j, err := h.getJob(id)
+	if errors.Is(err, errJobNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler: GetJob", "Failed to get job", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(j)
+}
+
+// GetJobKeysHandler отдаёт ключи задачи страницами: ?after=<next_after из прошлого ответа>&limit=N
+func (h *Handler) GetJobKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid job id"})
+		return
+	}
+	after, limit, err := parsePage(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid page parameters: " + err.Error()})
+		return
+	}
+
+	if _, err := h.getJob(id); errors.Is(err, errJobNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Job not found"})
+		return
+	} else if err != nil {
+		h.logger.Error("handler: GetJobKeys", "Failed to get job", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	keys, nextAfter, err := h.listJobKeys(id, after, limit)
+	if err != nil {
+		h.logger.Error("handler: GetJobKeys", "Failed to list job keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	type JobKeysResponse struct {
+		JobID     int64    `json:"job_id"`
+		Keys      []string `json:"keys"`
+		NextAfter int64    `json:"next_after,omitempty"`
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&JobKeysResponse{JobID: id, Keys: keys, NextAfter: nextAfter})
+}
+
+// parsePage читает параметры постраничной выдачи по id: after и limit
+func parsePage(r *http.Request) (int64, int, error) {
+	var (
+		after int64
+		limit = defaultPageLimit
+		err   error
+	)
+	if value := r.URL.Query().Get("after"); value != "" {
+		after, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || after < 0 {
+			return 0, 0, errors.New("invalid after parameter")
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+	return after, limit, nil
+}
+
+// enqueueGenerateJob создаёт партию и задачу на её генерацию и будит воркеров
+func (h *Handler) enqueueGenerateJob(b *batch, opts keyInsertOptions) (*job, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertBatch(tx, b); err != nil {
+		return nil, err
+	}
+	// задача сразу резервирует весь объём, чтобы параллельные запросы его не заняли
+	if err := reserveCapacity(tx, b.Group, b.RequestedCount, opts.capacityLimit); err != nil {
+		return nil, err
+	}
+	metadata, err := jsonObject(opts.metadata)
+	if err != nil {
+		return nil, err
+	}
+	j, err := scanJob(tx.QueryRow(`INSERT INTO jobs (group_name, requested_count, status, valid_from, expires_at,
+			max_uses, max_uses_per_subject, batch_id, key_metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING `+jobColumns,
+		b.Group, b.RequestedCount, jobStatusPending, nullTime(opts.window.ValidFrom), nullTime(opts.window.ExpiresAt),
+		opts.maxUses, opts.maxUsesPerSubject, b.ID, metadata))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case h.jobWakeup <- struct{}{}:
+	default:
+	}
+	return j, nil
+}
+
+func (h *Handler) getJob(id int64) (*job, error) {
+	j, err := scanJob(h.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (h *Handler) listJobKeys(jobID, after int64, limit int) ([]string, int64, error) {
+	rows, err := h.db.Query("SELECT id, key_value FROM keys WHERE job_id = $1 AND id > $2 AND key_value IS NOT NULL ORDER BY id LIMIT $3", jobID, after, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	var lastID int64
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&lastID, &key); err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) < limit {
+		lastID = 0
+	}
+	return keys, lastID, rows.Err()
+}
+
+// StartJobWorkers запускает пул воркеров фоновой генерации.
+// Возвращённая функция останавливает воркеров и ждёт их завершения.
+func (h *Handler) StartJobWorkers() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	for i := 0; i < h.cfg.JobWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.jobWorker(ctx)
+		}()
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (h *Handler) jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// разбираем очередь, пока в ней есть задачи
+		for ctx.Err() == nil {
+			j, err := h.claimJob()
+			if err != nil {
+				h.logger.Error("jobs", "Failed to claim job", err)
+				break
+			}
+			if j == nil {
+				break
+			}
+			h.runJob(ctx, j)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.jobWakeup:
+		}
+	}
+}
+
+// claimJob берёт свободную задачу или задачу, чей воркер перестал обновлять heartbeat
+func (h *Handler) claimJob() (*job, error) {
+	token := newLeaseToken()
+	j, err := scanJob(h.db.QueryRow(`UPDATE jobs SET status = $1, lease_token = $4, heartbeat_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = $2 OR (status = $1 AND heartbeat_at < NOW() - $3 * INTERVAL '1 second')
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING `+jobColumns, jobStatusRunning, jobStatusPending, int(jobLeaseTimeout.Seconds()), token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j.leaseToken = token
+	return j, nil
+}
+
+func newLeaseToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic("random source failed: " + err.Error())
+	}
+	return hex.EncodeToString(token)
+}
+
+// execer - *sql.DB или *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// updateLeasedJob обновляет задачу, только пока она за этим воркером
+func updateLeasedJob(exec execer, j *job, set string, args ...any) error {
+	result, err := exec.Exec("UPDATE jobs SET "+set+", updated_at = NOW() WHERE id = $1 AND lease_token = $2 AND status = '"+jobStatusRunning+"'",
+		append([]any{j.ID, j.leaseToken}, args...)...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errJobLeaseLost
+	}
+	return nil
+}
+
+// heartbeat продлевает аренду задачи, пока не отменён ctx
+func (h *Handler) heartbeat(ctx context.Context, j *job) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := updateLeasedJob(h.db, j, "heartbeat_at = NOW()")
+		if errors.Is(err, errJobLeaseLost) {
+			// задачу забрал другой воркер, текущая порция не закоммитится
+			return
+		}
+		if err != nil {
+			h.logger.Error("jobs", fmt.Sprintf("Failed to extend lease of job %d", j.ID), err)
+		}
+	}
+}
+
+func (h *Handler) runJob(ctx context.Context, j *job) {
+	h.logger.Info("jobs", fmt.Sprintf("Job %d started: %d/%d keys of group %s", j.ID, j.GeneratedCount, j.RequestedCount, j.Group))
+
+	g, err := h.getGroup(j.Group)
+	if err != nil {
+		h.failJob(j, err)
+		return
+	}
+	pattern, err := g.keyPattern()
+	if err != nil {
+		h.failJob(j, err)
+		return
+	}
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	defer stopHeartbeat()
+	go h.heartbeat(heartbeatCtx, j)
+
+	source := keygen.NewCryptoSource()
+	for j.GeneratedCount < j.RequestedCount {
+		if ctx.Err() != nil {
+			// сервер останавливается: отпускаем задачу, после рестарта её доделают
+			if err := updateLeasedJob(h.db, j, "status = $3, lease_token = NULL", jobStatusPending); err != nil {
+				h.logger.Error("jobs", fmt.Sprintf("Failed to release job %d", j.ID), err)
+			}
+			return
+		}
+
+		chunk := min(jobChunkSize, j.RequestedCount-j.GeneratedCount)
+		err := h.runJobChunk(j, g, pattern, chunk, source)
+		if errors.Is(err, errJobLeaseLost) {
+			h.logger.Warn("jobs", fmt.Sprintf("Job %d was taken over by another worker", j.ID))
+			return
+		}
+		if err != nil {
+			h.failJob(j, err)
+			return
+		}
+		j.GeneratedCount += chunk
+	}
+
+	if err := updateLeasedJob(h.db, j, "status = $3, finished_at = NOW()", jobStatusDone); err != nil {
+		h.logger.Error("jobs", fmt.Sprintf("Failed to finish job %d", j.ID), err)
+		return
+	}
+	h.logger.Info("jobs", fmt.Sprintf("Job %d done", j.ID))
+}
+
+// runJobChunk вставляет порцию ключей и двигает счётчик задачи в одной транзакции
+func (h *Handler) runJobChunk(j *job, g *group, pattern *keygen.Pattern, count int, source *keygen.Source) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	opts := keyInsertOptions{
+		jobID:             sql.NullInt64{Int64: j.ID, Valid: true},
+		batchID:           nullInt64(j.BatchID),
+		window:            j.validityWindow,
+		maxUses:           j.MaxUses,
+		maxUsesPerSubject: j.MaxUsesPerSubject,
+		metadata:          j.Metadata,
+	}
+	if _, err := h.insertGeneratedKeys(tx, g, pattern, count, source, opts); err != nil {
+		return err
+	}
+	// задачу могли забрать, пока шла порция: тогда её ключи откатываются
+	if err := updateLeasedJob(tx, j, "generated_count = generated_count + $3, heartbeat_at = NOW()", count); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (h *Handler) failJob(j *job, jobErr error) {
+	h.logger.Error("jobs", fmt.Sprintf("Job %d failed", j.ID), jobErr)
+	if err := h.markJobFailed(j, jobErr); err != nil {
+		h.logger.Error("jobs", fmt.Sprintf("Failed to mark job %d as failed", j.ID), err)
+	}
+}
+
+// markJobFailed завершает задачу с ошибкой и возвращает группе невыпущенный остаток резерва
+func (h *Handler) markJobFailed(j *job, jobErr error) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateLeasedJob(tx, j, "status = $3, error = $4, finished_at = NOW()", jobStatusFailed, jobErr.Error()); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE groups SET issued_count = GREATEST(issued_count - (
+			SELECT requested_count - generated_count FROM jobs WHERE id = $1), 0)
+		WHERE name = $2`, j.ID, j.Group)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
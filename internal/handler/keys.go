@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyInfo - выпущенный ключ в ответах API. У ключей секретных групп
+// вместо самого ключа отдаётся только префикс.
+type keyInfo struct {
+	ID           int64           `json:"id"`
+	Key          string          `json:"key,omitempty"`
+	KeyPrefix    string          `json:"key_prefix,omitempty"`
+	Group        string          `json:"group"`
+	Pattern      string          `json:"pattern"`
+	Status       string          `json:"status"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ValidFrom    *time.Time      `json:"valid_from,omitempty"`
+	ExpiresAt    *time.Time      `json:"expires_at,omitempty"`
+	RedeemedAt   *time.Time      `json:"redeemed_at,omitempty"`
+	RedeemedBy   string          `json:"redeemed_by,omitempty"`
+	RevokedAt    *time.Time      `json:"revoked_at,omitempty"`
+	RevokedBy    string          `json:"revoked_by,omitempty"`
+	RevokeReason string          `json:"revoke_reason,omitempty"`
+	JobID        *int64          `json:"job_id,omitempty"`
+	BatchID      *int64          `json:"batch_id,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	UseCount     int             `json:"use_count"`
+	MaxUses      int             `json:"max_uses"`
+}
+
+const keyInfoColumns = `id, COALESCE(key_value, ''), COALESCE(key_prefix, ''), group_name, pattern, status, created_at,
+	valid_from, expires_at, redeemed_at, COALESCE(redeemed_by, ''), revoked_at, COALESCE(revoked_by, ''),
+	COALESCE(revoke_reason, ''), job_id, batch_id, use_count, max_uses, metadata`
+
+func scanKeyInfo(row rowScanner) (*keyInfo, error) {
+	k := &keyInfo{}
+	var (
+		validFrom, expiresAt, redeemedAt, revokedAt sql.NullTime
+		jobID, batchID                              sql.NullInt64
+		metadata                                    []byte
+	)
+	err := row.Scan(&k.ID, &k.Key, &k.KeyPrefix, &k.Group, &k.Pattern, &k.Status, &k.CreatedAt,
+		&validFrom, &expiresAt, &redeemedAt, &k.RedeemedBy, &revokedAt, &k.RevokedBy, &k.RevokeReason, &jobID,
+		&batchID, &k.UseCount, &k.MaxUses, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	k.ValidFrom = timePtr(validFrom)
+	k.ExpiresAt = timePtr(expiresAt)
+	k.RedeemedAt = timePtr(redeemedAt)
+	k.RevokedAt = timePtr(revokedAt)
+	if jobID.Valid {
+		k.JobID = &jobID.Int64
+	}
+	if batchID.Valid {
+		k.BatchID = &batchID.Int64
+	}
+	if metadata != nil {
+		k.Metadata = metadata
+	}
+	return k, nil
+}
+
+func (h *Handler) GetKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key, err := keyParam(r)
+	if err != nil || key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid key"})
+		return
+	}
+
+	key, err = h.resolveKey(key)
+	if err != nil {
+		h.logger.Error("handler: GetKey", "Failed to resolve key", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	info, err := scanKeyInfo(h.db.QueryRow("SELECT "+keyInfoColumns+" FROM keys WHERE key_value = $1 OR key_hash = $2",
+		key, h.hashKey(key)))
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("handler: GetKey", "Failed to get key", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(info)
+}
+
+// ListKeysHandler отдаёт ключи страницами по id (?after=&limit=) с фильтрами
+// group_name, batch, status, created_from, created_to, prefix и metadata.<поле>
+func (h *Handler) ListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	after, limit, err := parsePage(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid page parameters: " + err.Error()})
+		return
+	}
+	filter, err := parseKeyFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid filter: " + err.Error()})
+		return
+	}
+
+	where, args := filter.where()
+	args = append(args, after, limit)
+	rows, err := h.db.Query(fmt.Sprintf("SELECT %s FROM keys WHERE %s AND id > $%d ORDER BY id LIMIT $%d",
+		keyInfoColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		h.logger.Error("handler: ListKeys", "Failed to list keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []keyInfo{}
+	for rows.Next() {
+		info, err := scanKeyInfo(rows)
+		if err != nil {
+			h.logger.Error("handler: ListKeys", "Failed to scan key", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			return
+		}
+		keys = append(keys, *info)
+	}
+	if err := rows.Err(); err != nil {
+		h.logger.Error("handler: ListKeys", "Failed to list keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	type ListKeysResponse struct {
+		Keys      []keyInfo `json:"keys"`
+		NextAfter int64     `json:"next_after,omitempty"`
+	}
+	response := &ListKeysResponse{Keys: keys}
+	if len(keys) == limit {
+		response.NextAfter = keys[len(keys)-1].ID
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// keyFilter - условия выборки ключей для списка и выгрузки
+type keyFilter struct {
+	Group       string
+	BatchID     *int64
+	Status      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Prefix      string
+	// поля метаданных верхнего уровня и их значения
+	Metadata map[string]string
+}
+
+func parseKeyFilter(r *http.Request) (*keyFilter, error) {
+	query := r.URL.Query()
+	filter := &keyFilter{
+		Group:  query.Get("group_name"),
+		Status: query.Get("status"),
+		Prefix: query.Get("prefix"),
+	}
+
+	var err error
+	if filter.Metadata, err = parseMetadataFilter(query); err != nil {
+		return nil, err
+	}
+	if value := query.Get("batch"); value != "" {
+		batchID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("batch must be a batch id")
+		}
+		filter.BatchID = &batchID
+	}
+
+	switch filter.Status {
+	case "", keyStatusActive, keyStatusRedeemed, keyStatusRevoked, keyStatusExpired:
+	default:
+		return nil, fmt.Errorf("unknown status %q", filter.Status)
+	}
+	for name, target := range map[string]**time.Time{"created_from": &filter.CreatedFrom, "created_to": &filter.CreatedTo} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC3339 time", name)
+			}
+			*target = &parsed
+		}
+	}
+	return filter, nil
+}
+
+// where собирает условие WHERE и его параметры начиная с $1
+func (f *keyFilter) where() (string, []any) {
+	conditions := []string{"TRUE"}
+	args := []any{}
+	add := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Group != "" {
+		add("group_name = $%d", f.Group)
+	}
+	if f.BatchID != nil {
+		add("batch_id = $%d", *f.BatchID)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.CreatedFrom != nil {
+		add("created_at >= $%d", *f.CreatedFrom)
+	}
+	if f.CreatedTo != nil {
+		add("created_at < $%d", *f.CreatedTo)
+	}
+	if f.Prefix != "" {
+		// у секретных ключей ищем по отображаемому префиксу
+		add("(key_value LIKE $%[1]d OR key_prefix LIKE $%[1]d)", escapeLike(f.Prefix)+"%")
+	}
+	for _, documents := range metadataConditions(f.Metadata) {
+		alternatives := make([]string, len(documents))
+		for i, document := range documents {
+			args = append(args, document)
+			alternatives[i] = fmt.Sprintf("metadata @> $%d::jsonb", len(args))
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// escapeLike экранирует спецсимволы LIKE, чтобы префикс искался буквально
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+)
+
+// Состояния ключа, совпадают со значениями enum key_status в базе
+const (
+	keyStatusActive   = "active"
+	keyStatusRedeemed = "redeemed"
+	keyStatusRevoked  = "revoked"
+	keyStatusExpired  = "expired"
+)
+
+// Причины, по которым ключ признан действующим или нет при проверке по базе
+const (
+	reasonOK           = "ok"
+	reasonBadFormat    = "bad_format"
+	reasonNotFound     = "not_found"
+	reasonWrongGroup   = "wrong_group"
+	reasonRedeemed     = "redeemed"
+	reasonRevoked      = "revoked"
+	reasonExpired      = "expired"
+	reasonNotYetActive = "not_yet_active"
+)
+
+// keyState - всё, что нужно знать о выпущенном ключе, чтобы решить, действует ли он
+type keyState struct {
+	ID          int64
+	Group       string
+	Status      string
+	KeyWindow   validityWindow
+	GroupWindow validityWindow
+	// лимиты многоразового ключа, см. redeemKey
+	UseCount          int
+	MaxUses           int
+	MaxUsesPerSubject int
+	Metadata          json.RawMessage
+	// ключ секретной группы: в базе только его HMAC и показываемый префикс
+	Hashed bool
+	Prefix string
+}
+
+// колонки для scanKeyState, ключи выбираются как k, их группа как g
+const keyStateColumns = `k.id, k.group_name, k.status, k.valid_from, k.expires_at, g.valid_from, g.expires_at,
+	k.use_count, k.max_uses, k.max_uses_per_subject, k.metadata, k.key_hash IS NOT NULL, COALESCE(k.key_prefix, '')`
+
+// хранимое значение ключа: сам ключ или его HMAC для секретных групп
+const keyStoredValue = `COALESCE(k.key_value, k.key_hash)`
+
+func scanKeyState(row rowScanner, extra ...any) (*keyState, error) {
+	var (
+		keyFrom, keyExpires, groupFrom, groupExpires sql.NullTime
+		metadata                                     []byte
+	)
+	state := &keyState{}
+	dest := append([]any{&state.ID, &state.Group, &state.Status, &keyFrom, &keyExpires, &groupFrom, &groupExpires,
+		&state.UseCount, &state.MaxUses, &state.MaxUsesPerSubject, &metadata, &state.Hashed, &state.Prefix}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		state.Metadata = metadata
+	}
+	state.KeyWindow = validityWindow{ValidFrom: timePtr(keyFrom), ExpiresAt: timePtr(keyExpires)}
+	state.GroupWindow = validityWindow{ValidFrom: timePtr(groupFrom), ExpiresAt: timePtr(groupExpires)}
+	return state, nil
+}
+
+// reason возвращает причину для ключа, найденного в базе, при проверке в группе groupName
+func (s *keyState) reason(groupName string, now time.Time) string {
+	if s.Group != groupName {
+		return reasonWrongGroup
+	}
+	return s.usability(now)
+}
+
+// usability проверяет статус ключа и окна действия ключа и группы
+func (s *keyState) usability(now time.Time) string {
+	switch s.Status {
+	case keyStatusRedeemed:
+		return reasonRedeemed
+	case keyStatusRevoked:
+		return reasonRevoked
+	case keyStatusExpired:
+		return reasonExpired
+	}
+	for _, window := range []validityWindow{s.KeyWindow, s.GroupWindow} {
+		switch window.check(now) {
+		case errKeyExpired:
+			return reasonExpired
+		case errKeyNotYetActive:
+			return reasonNotYetActive
+		}
+	}
+	return reasonOK
+}
+
+// lookupKeyStates одним запросом находит переданные ключи - и открытые, и хранимые
+// в виде HMAC. Ключи, которых нет в базе, в результат не попадают.
+func (h *Handler) lookupKeyStates(keys []string) (map[string]*keyState, error) {
+	// сохранённое значение (ключ или его HMAC) -> ключ из запроса
+	stored := make(map[string]string, len(keys)*2)
+	hashes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		stored[key] = key
+		if hash := h.hashKey(key); hash.Valid {
+			stored[hash.String] = key
+			hashes = append(hashes, hash.String)
+		}
+	}
+
+	rows, err := h.db.Query(`SELECT `+keyStateColumns+`, `+keyStoredValue+`
+		FROM keys k LEFT JOIN groups g ON g.name = k.group_name
+		WHERE k.key_value = ANY($1) OR k.key_hash = ANY($2)`, keys, hashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]*keyState, len(keys))
+	for rows.Next() {
+		var value string
+		state, err := scanKeyState(rows, &value)
+		if err != nil {
+			return nil, err
+		}
+		states[stored[value]] = state
+	}
+	return states, rows.Err()
+}
+
+// lockKeyState находит ключ и блокирует его строку до конца транзакции, так что
+// параллельные погашения и отзывы одного ключа выполняются по очереди
+func (h *Handler) lockKeyState(tx *sql.Tx, key string) (*keyState, error) {
+	state, err := scanKeyState(tx.QueryRow(`SELECT `+keyStateColumns+`
+		FROM keys k LEFT JOIN groups g ON g.name = k.group_name
+		WHERE k.key_value = $1 OR k.key_hash = $2
+		FOR UPDATE OF k`, key, h.hashKey(key)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// resolveKey возвращает ключ в том виде, в каком он выпущен. Погашение, карточка ключа
+// и отзыв не знают группу, поэтому если ключа нет как есть, он приводится к шаблону
+// каждой группы (см. keygen.Pattern.NormalizeKey) и берётся первый найденный вариант
+func (h *Handler) resolveKey(key string) (string, error) {
+	resolved, err := h.resolveKeys([]string{key})
+	if err != nil {
+		return "", err
+	}
+	return resolved[0], nil
+}
+
+// resolveKeys - resolveKey для списка ключей; ненайденные ключи остаются как есть
+func (h *Handler) resolveKeys(keys []string) ([]string, error) {
+	states, err := h.lookupKeyStates(keys)
+	if err != nil {
+		return nil, err
+	}
+	resolved := append([]string(nil), keys...)
+	if len(states) == len(keys) {
+		return resolved, nil
+	}
+
+	patterns, err := h.groupPatterns()
+	if err != nil || len(patterns) == 0 {
+		return resolved, err
+	}
+	variants := make([][]string, len(keys))
+	var all []string
+	for i, key := range keys {
+		if states[key] != nil {
+			continue
+		}
+		variants[i] = keyVariants(key, patterns)
+		all = append(all, variants[i]...)
+	}
+	if len(all) == 0 {
+		return resolved, nil
+	}
+	found, err := h.lookupKeyStates(all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		for _, variant := range variants[i] {
+			if found[variant] != nil {
+				resolved[i] = variant
+				break
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// groupPatterns - шаблоны всех групп в порядке имён. Нужны не только группы с
+// нормализацией: без неё NormalizeKey тоже приводит регистр строчных букв, которые
+// принимает проверка, и регистр стандартных идентификаторов.
+func (h *Handler) groupPatterns() ([]*keygen.Pattern, error) {
+	rows, err := h.db.Query("SELECT " + groupColumns + " FROM groups ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var patterns []*keygen.Pattern
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := g.keyPattern()
+		if err != nil {
+			h.logger.Error("handler", "Invalid pattern stored for group "+g.Name, err)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, rows.Err()
+}
+
+// keyVariants - отличные от key результаты нормализации по шаблонам, без повторов
+func keyVariants(key string, patterns []*keygen.Pattern) []string {
+	var variants []string
+	seen := map[string]struct{}{key: {}}
+	for _, pattern := range patterns {
+		variant := pattern.NormalizeKey(key)
+		if _, ok := seen[variant]; ok {
+			continue
+		}
+		seen[variant] = struct{}{}
+		variants = append(variants, variant)
+	}
+	return variants
+}
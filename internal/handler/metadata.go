@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Метаданные - произвольный JSON-объект на группе, ключе, партии или погашении.
+// Метаданные группы наследуются ключами при генерации, поля запроса их перекрывают.
+
+const metadataFilterPrefix = "metadata."
+
+var errBadMetadata = errors.New("metadata must be a JSON object")
+
+// jsonObject проверяет, что переданные метаданные - JSON-объект, и возвращает
+// значение для колонки JSONB (nil, если метаданных нет)
+func jsonObject(raw json.RawMessage) (any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] != '{' {
+		return nil, errBadMetadata
+	}
+	return string(raw), nil
+}
+
+// mergeMetadata накладывает поля верхнего уровня override на base
+func mergeMetadata(base, override json.RawMessage) (json.RawMessage, error) {
+	merged := map[string]json.RawMessage{}
+	for _, raw := range []json.RawMessage{base, override} {
+		if _, err := jsonObject(raw); err != nil {
+			return nil, err
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, errBadMetadata
+		}
+		for name, value := range fields {
+			merged[name] = value
+		}
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(merged)
+}
+
+// parseMetadataFilter читает из query параметры вида metadata.<поле>=<значение>
+func parseMetadataFilter(query map[string][]string) (map[string]string, error) {
+	filter := map[string]string{}
+	for name, values := range query {
+		field, ok := strings.CutPrefix(name, metadataFilterPrefix)
+		if !ok {
+			continue
+		}
+		if field == "" {
+			return nil, errors.New("metadata filter needs a field name")
+		}
+		if len(values) != 1 {
+			return nil, fmt.Errorf("metadata filter %q must be given once", field)
+		}
+		filter[field] = values[0]
+	}
+	return filter, nil
+}
+
+// metadataConditions превращает фильтр в набор JSON-документов для оператора @>.
+// Значение из query - строка, поэтому для каждого поля подходит любой из вариантов:
+// строка, число или логическое значение с тем же текстом, а также массив, в котором
+// есть это значение (например, список разрешённых категорий).
+func metadataConditions(filter map[string]string) [][]string {
+	fields := make([]string, 0, len(filter))
+	for field := range filter {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	conditions := make([][]string, 0, len(fields))
+	for _, field := range fields {
+		value := filter[field]
+		values := []any{value}
+		if number, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+			values = append(values, json.Number(strconv.FormatFloat(number, 'f', -1, 64)))
+		}
+		if value == "true" || value == "false" {
+			values = append(values, value == "true")
+		}
+
+		documents := make([]string, 0, len(values)*2)
+		for _, v := range values {
+			for _, document := range []map[string]any{{field: v}, {field: []any{v}}} {
+				encoded, _ := json.Marshal(document)
+				documents = append(documents, string(encoded))
+			}
+		}
+		conditions = append(conditions, documents)
+	}
+	return conditions
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergeMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		want     string
+		err      error
+	}{
+		{name: "nothing", want: ""},
+		{name: "null both", base: "null", override: "null", want: ""},
+		{name: "empty objects", base: "{}", override: "{}", want: ""},
+		{name: "base only", base: `{"discount":10}`, want: `{"discount":10}`},
+		{name: "override only", override: `{"partner_id":"p-1"}`, want: `{"partner_id":"p-1"}`},
+		// null вместо объекта означает «метаданных нет» и не стирает базовые
+		{name: "null override keeps base", base: `{"discount":10}`, override: "null", want: `{"discount":10}`},
+		{name: "null base", base: "null", override: `{"discount":10}`, want: `{"discount":10}`},
+		{
+			name:     "override wins",
+			base:     `{"discount":10,"currency":"RUB"}`,
+			override: `{"discount":15}`,
+			want:     `{"currency":"RUB","discount":15}`,
+		},
+		// null в поле - обычное значение: поле остаётся и перекрывает базовое
+		{name: "null field overwrites", base: `{"discount":10}`, override: `{"discount":null}`, want: `{"discount":null}`},
+		// перекрываются только поля верхнего уровня, вложенные объекты не сливаются
+		{
+			name:     "nested object is replaced",
+			base:     `{"limits":{"daily":5,"total":50}}`,
+			override: `{"limits":{"daily":1}}`,
+			want:     `{"limits":{"daily":1}}`,
+		},
+		{
+			name:     "array is replaced",
+			base:     `{"categories":["food","books"]}`,
+			override: `{"categories":["toys"]}`,
+			want:     `{"categories":["toys"]}`,
+		},
+		{name: "base not an object", base: `[1,2]`, override: `{"a":1}`, err: errBadMetadata},
+		{name: "override not an object", base: `{"a":1}`, override: `"text"`, err: errBadMetadata},
+		{name: "override is a number", override: `10`, err: errBadMetadata},
+		{name: "broken object", override: `{"a":`, err: errBadMetadata},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeMetadata(json.RawMessage(tt.base), json.RawMessage(tt.override))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("mergeMetadata(%s, %s) error = %v, want %v", tt.base, tt.override, err, tt.err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeMetadata(%s, %s) = %s, want %s", tt.base, tt.override, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataConditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter map[string]string
+		want   [][]string
+	}{
+		{name: "no filter", filter: map[string]string{}, want: [][]string{}},
+		{
+			name:   "string",
+			filter: map[string]string{"currency": "RUB"},
+			want:   [][]string{{`{"currency":"RUB"}`, `{"currency":["RUB"]}`}},
+		},
+		{
+			name:   "number",
+			filter: map[string]string{"discount": "10"},
+			want: [][]string{{
+				`{"discount":"10"}`, `{"discount":["10"]}`,
+				`{"discount":10}`, `{"discount":[10]}`,
+			}},
+		},
+		// число приводится к каноническому виду JSONB: 10.50 и 1e1 хранятся как 10.5 и 10
+		{
+			name:   "number in another notation",
+			filter: map[string]string{"discount": "1e1"},
+			want: [][]string{{
+				`{"discount":"1e1"}`, `{"discount":["1e1"]}`,
+				`{"discount":10}`, `{"discount":[10]}`,
+			}},
+		},
+		{
+			name:   "boolean",
+			filter: map[string]string{"vip": "true"},
+			want: [][]string{{
+				`{"vip":"true"}`, `{"vip":["true"]}`,
+				`{"vip":true}`, `{"vip":[true]}`,
+			}},
+		},
+		{
+			name:   "not a number",
+			filter: map[string]string{"note": "NaN"},
+			want:   [][]string{{`{"note":"NaN"}`, `{"note":["NaN"]}`}},
+		},
+		// фильтр смотрит только на поля верхнего уровня: точка - часть имени поля,
+		// а не путь во вложенный объект
+		{
+			name:   "dotted field is top level",
+			filter: map[string]string{"partner.id": "p-1"},
+			want:   [][]string{{`{"partner.id":"p-1"}`, `{"partner.id":["p-1"]}`}},
+		},
+		{
+			name:   "fields are sorted",
+			filter: map[string]string{"currency": "RUB", "category": "food"},
+			want: [][]string{
+				{`{"category":"food"}`, `{"category":["food"]}`},
+				{`{"currency":"RUB"}`, `{"currency":["RUB"]}`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metadataConditions(tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metadataConditions(%v) = %q, want %q", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMetadataFilter(t *testing.T) {
+	filter, err := parseMetadataFilter(map[string][]string{
+		"metadata.currency": {"RUB"},
+		"metadata.a.b":      {"1"},
+		"status":            {"active"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"currency": "RUB", "a.b": "1"}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("parseMetadataFilter() = %v, want %v", filter, want)
+	}
+
+	for _, query := range []map[string][]string{
+		{"metadata.": {"x"}},
+		{"metadata.currency": {"RUB", "USD"}},
+	} {
+		if _, err := parseMetadataFilter(query); err == nil {
+			t.Errorf("parseMetadataFilter(%v) accepted a bad filter", query)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MetricsHandler отдаёт счётчики сервиса в текстовом формате Prometheus
+func (h *Handler) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintln(w, "# HELP keys_denylist_rejected_total Generated key candidates rejected by the denylist.")
+	fmt.Fprintln(w, "# TYPE keys_denylist_rejected_total counter")
+	fmt.Fprintf(w, "keys_denylist_rejected_total %d\n", h.denylist.rejected.Load())
+
+	fmt.Fprintln(w, "# HELP keys_denylist_words Number of words in the denylist.")
+	fmt.Fprintln(w, "# TYPE keys_denylist_words gauge")
+	fmt.Fprintf(w, "keys_denylist_words %d\n", len(h.denylist.list()))
+}
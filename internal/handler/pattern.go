@@ -1,9 +0,0 @@
-package handler
-
-var patternTemplates = map[string]string{
-	"promo":      "AVITO-XXXX-XXXX",    // Промокоды
-	"discount":   "AVITO-DISC-XXX",     // Скидочные купоны
-	"user_token": "AVITO-USER-XXXXXX",  // Токены пользователей
-	"api_key":    "AVITO-API-XXXXXXXX", // API ключи
-	"partner":    "AVITO-PART-XXXX",    // Партнерские ключи
-}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+)
+
+// В режиме permuted группа не тянет случайных кандидатов: номер ключа берётся из
+// счётчика groups.key_counter и переводится ключевой перестановкой (сеть Фейстеля
+// поверх пространства ключей шаблона) в индекс ключа. Разные номера дают разные
+// ключи, поэтому повторов нет по построению, а без permutation_key по номеру ключа
+// нельзя угадать соседние. Счётчик сдвигается в той же транзакции, что и вставка,
+// так что несколько экземпляров сервиса не выдают один номер дважды.
+
+const (
+	generationRandom   = "random"
+	generationPermuted = "permuted"
+)
+
+var errBadGeneration = errors.New("generation must be random or permuted")
+
+// reserveCounter сдвигает счётчик группы на count и возвращает первый выданный номер
+func reserveCounter(tx *sql.Tx, groupName string, count int) (int64, error) {
+	var next int64
+	err := tx.QueryRow("UPDATE groups SET key_counter = key_counter + $2 WHERE name = $1 RETURNING key_counter",
+		groupName, count).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errGroupNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return next - int64(count), nil
+}
+
+// insertPermutedKeys выпускает count ключей группы в режиме permuted. Конфликты
+// возможны только с ключами, выпущенными до включения режима или импортированными,
+// такие номера просто пропускаются.
+func (h *Handler) insertPermutedKeys(tx *sql.Tx, g *group, pattern *keygen.Pattern, count int, opts keyInsertOptions) ([]string, error) {
+	keys := make([]string, 0, count)
+	emptyRounds := 0
+	createdAt := time.Now()
+
+	for len(keys) < count {
+		need := min(count-len(keys), insertBatchSize)
+		first, err := reserveCounter(tx, g.Name, need)
+		if err != nil {
+			return nil, err
+		}
+		last := big.NewInt(first + int64(need) - 1)
+		if last.Cmp(pattern.Permutation.Domain()) >= 0 {
+			return nil, keygen.ErrKeyspaceExhausted
+		}
+
+		candidates := make([]string, 0, need)
+		for i := 0; i < need; i++ {
+			key := pattern.KeyAt(pattern.Permutation.Apply(big.NewInt(first + int64(i))))
+			if !h.denylist.allows(key, pattern) {
+				h.denylist.rejected.Add(1)
+				continue
+			}
+			candidates = append(candidates, key)
+		}
+
+		inserted := []string{}
+		if len(candidates) > 0 {
+			inserted, err = h.insertKeyBatch(tx, g, pattern, candidates, createdAt, opts)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(inserted) == 0 {
+			emptyRounds++
+			if emptyRounds >= maxEmptyRounds {
+				return nil, keygen.ErrKeyspaceExhausted
+			}
+			continue
+		}
+		emptyRounds = 0
+		keys = append(keys, inserted...)
+	}
+	return keys, nil
+}
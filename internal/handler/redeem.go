@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+var (
+	errKeyNotFound          = errors.New("key not found")
+	errKeyAlreadyRedeemed   = errors.New("key already redeemed")
+	errKeyRevoked           = errors.New("key revoked")
+	errSubjectUsesExhausted = errors.New("subject has used the key the maximum number of times")
+)
+
+type redeemedKey struct {
+	Key        string    `json:"key"`
+	Group      string    `json:"group"`
+	RedeemedBy string    `json:"redeemed_by"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+	// сколько раз ключ использован всего и сколько использований осталось
+	UseCount      int `json:"use_count"`
+	MaxUses       int `json:"max_uses"`
+	RemainingUses int `json:"remaining_uses"`
+	// метаданные ключа (скидка, партнёр и т.п.)
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// префикс ключа секретной группы, см. redactIdempotentKey
+	secretPrefix string
+}
+
+// redemptionRequest - кто и откуда гасит ключ, пишется в журнал key_redemptions
+type redemptionRequest struct {
+	SubjectID string
+	RequestID string
+	ClientIP  string
+	UserAgent string
+	Metadata  json.RawMessage
+}
+
+func (h *Handler) RedeemKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Key        string `json:"key"`
+		RedeemedBy string `json:"redeemed_by"`
+		// произвольные данные запроса (заказ, канал и т.п.) для журнала погашений
+		Metadata json.RawMessage `json:"metadata"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if request.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key is required"})
+		return
+	}
+	if request.RedeemedBy == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Redeemed_by is required"})
+		return
+	}
+
+	redeemed, err := h.redeemKey(request.Key, redemptionRequest{
+		SubjectID: request.RedeemedBy,
+		RequestID: middleware.GetReqID(r.Context()),
+		ClientIP:  r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Metadata:  request.Metadata,
+	})
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key not found"})
+		return
+	case errors.Is(err, errKeyAlreadyRedeemed):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key already redeemed"})
+		return
+	case errors.Is(err, errSubjectUsesExhausted):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key usage limit for this customer is reached"})
+		return
+	case errors.Is(err, errKeyRevoked):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key revoked"})
+		return
+	case errors.Is(err, errKeyExpired):
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key expired"})
+		return
+	case errors.Is(err, errKeyNotYetActive):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key is not active yet"})
+		return
+	case errors.Is(err, errBadMetadata):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Metadata must be a JSON object"})
+		return
+	case err != nil:
+		h.logger.Error("handler: RedeemKey", "Failed to redeem key", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	if redeemed.secretPrefix != "" {
+		redactIdempotentKey(r, redeemed.Key, redeemed.secretPrefix)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(redeemed)
+}
+
+// redeemKey гасит одно использование ключа в одной транзакции. Строка ключа
+// блокируется через FOR UPDATE, поэтому параллельные погашения одного ключа идут
+// по очереди и оба лимита (общий и на клиента) считаются без двойного учёта.
+// После последнего разрешённого использования ключ переходит в статус redeemed.
+func (h *Handler) redeemKey(key string, request redemptionRequest) (*redeemedKey, error) {
+	metadata, err := jsonObject(request.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	// введённый вручную ключ приводится к виду, в котором выпущен
+	key, err = h.resolveKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	state, err := h.lockKeyState(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// ключ действует только в статусе active и внутри своего окна и окна группы
+	now := time.Now()
+	switch state.usability(now) {
+	case reasonRedeemed:
+		return nil, errKeyAlreadyRedeemed
+	case reasonRevoked:
+		return nil, errKeyRevoked
+	case reasonExpired:
+		return nil, errKeyExpired
+	case reasonNotYetActive:
+		return nil, errKeyNotYetActive
+	}
+	if state.UseCount >= state.MaxUses {
+		return nil, errKeyAlreadyRedeemed
+	}
+
+	if state.MaxUsesPerSubject > 0 {
+		var subjectUses int
+		err = tx.QueryRow("SELECT COUNT(*) FROM key_redemptions WHERE key_id = $1 AND subject_id = $2",
+			state.ID, request.SubjectID).Scan(&subjectUses)
+		if err != nil {
+			return nil, err
+		}
+		if subjectUses >= state.MaxUsesPerSubject {
+			return nil, errSubjectUsesExhausted
+		}
+	}
+
+	redeemed := &redeemedKey{
+		Key:        key,
+		Group:      state.Group,
+		RedeemedBy: request.SubjectID,
+		UseCount:   state.UseCount + 1,
+		MaxUses:    state.MaxUses,
+		Metadata:   state.Metadata,
+	}
+	redeemed.RemainingUses = redeemed.MaxUses - redeemed.UseCount
+	if state.Hashed {
+		redeemed.secretPrefix = state.Prefix
+	}
+
+	status := keyStatusActive
+	if redeemed.RemainingUses == 0 {
+		status = keyStatusRedeemed
+	}
+	err = tx.QueryRow(`UPDATE keys SET status = $2, use_count = use_count + 1, redeemed_at = $3, redeemed_by = $4
+		WHERE id = $1 RETURNING redeemed_at`,
+		state.ID, status, now, request.SubjectID).Scan(&redeemed.RedeemedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO key_redemptions (key_id, subject_id, redeemed_at, request_id, client_ip, user_agent, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		state.ID, request.SubjectID, now, request.RequestID, request.ClientIP, request.UserAgent, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return redeemed, nil
+}
@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Отзыв переводит ключ active -> revoked, восстановление - обратно revoked -> active.
+// Каждое действие записывается в key_events с причиной и автором.
+
+const (
+	keyEventRevoke    = "revoke"
+	keyEventReinstate = "reinstate"
+
+	// сколько ключей можно отозвать списком за один запрос
+	maxBulkRevokeKeys = 10000
+)
+
+var (
+	errKeyNotActive  = errors.New("key is not active")
+	errKeyNotRevoked = errors.New("key is not revoked")
+)
+
+type keyAction struct {
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"`
+}
+
+func (a keyAction) validate() error {
+	if a.Reason == "" {
+		return errors.New("reason is required")
+	}
+	if a.Actor == "" {
+		return errors.New("actor is required")
+	}
+	return nil
+}
+
+func (h *Handler) RevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeKeyStatusHandler(w, r, keyEventRevoke)
+}
+
+func (h *Handler) ReinstateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeKeyStatusHandler(w, r, keyEventReinstate)
+}
+
+// keyParam возвращает ключ из пути. Если в пути были экранированные символы, chi
+// берёт параметры из RawPath, и ключ нужно раскодировать; иначе параметр уже
+// раскодирован, и повторное раскодирование испортило бы ключ со знаком '%'
+func keyParam(r *http.Request) (string, error) {
+	key := chi.URLParam(r, "key")
+	if r.URL.RawPath == "" {
+		return key, nil
+	}
+	return url.PathUnescape(key)
+}
+
+func (h *Handler) changeKeyStatusHandler(w http.ResponseWriter, r *http.Request, action string) {
+	var request keyAction
+
+	w.Header().Set("Content-Type", "application/json")
+
+	key, err := keyParam(r)
+	if err != nil || key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid key"})
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if err := request.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	key, err = h.resolveKey(key)
+	if err != nil {
+		h.logger.Error("handler: ChangeKeyStatus", "Failed to resolve key", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	state, err := h.changeKeyStatus(key, action, request)
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Key not found"})
+		return
+	case errors.Is(err, errKeyNotActive):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Only active keys can be revoked"})
+		return
+	case errors.Is(err, errKeyNotRevoked):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Only revoked keys can be reinstated"})
+		return
+	case err != nil:
+		h.logger.Error("handler: ChangeKeyStatus", "Failed to "+action+" key", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	if state.Hashed {
+		redactIdempotentKey(r, key, state.Prefix)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"key": key, "status": state.Status})
+}
+
+// changeKeyStatus отзывает или восстанавливает один ключ и возвращает его с новым статусом
+func (h *Handler) changeKeyStatus(key, action string, request keyAction) (*keyState, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	state, err := h.lockKeyState(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	switch action {
+	case keyEventRevoke:
+		if state.Status != keyStatusActive {
+			return nil, errKeyNotActive
+		}
+		status = keyStatusRevoked
+		_, err = tx.Exec("UPDATE keys SET status = $2, revoked_at = $3, revoked_by = $4, revoke_reason = $5 WHERE id = $1",
+			state.ID, status, time.Now(), request.Actor, request.Reason)
+	case keyEventReinstate:
+		if state.Status != keyStatusRevoked {
+			return nil, errKeyNotRevoked
+		}
+		status = keyStatusActive
+		_, err = tx.Exec("UPDATE keys SET status = $2, revoked_at = NULL, revoked_by = NULL, revoke_reason = NULL WHERE id = $1",
+			state.ID, status)
+	default:
+		return nil, fmt.Errorf("unknown key action %q", action)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("INSERT INTO key_events (key_id, action, reason, actor) VALUES ($1, $2, $3, $4)",
+		state.ID, action, request.Reason, request.Actor)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	state.Status = status
+	return state, nil
+}
+
+// BulkRevokeKeysHandler отзывает ключи списком (keys) или по фильтру: группа и
+// необязательный диапазон даты создания [created_from, created_to)
+func (h *Handler) BulkRevokeKeysHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		keyAction
+		Keys        []string   `json:"keys"`
+		Group       string     `json:"group"`
+		CreatedFrom *time.Time `json:"created_from"`
+		CreatedTo   *time.Time `json:"created_to"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if err := request.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if (len(request.Keys) == 0) == (request.Group == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Either keys or group filter is required"})
+		return
+	}
+	if len(request.Keys) > maxBulkRevokeKeys {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("At most %d keys can be revoked at once", maxBulkRevokeKeys)})
+		return
+	}
+
+	var (
+		revoked int64
+		err     error
+	)
+	if len(request.Keys) > 0 {
+		revoked, err = h.bulkRevokeByKeys(request.Keys, request.keyAction)
+	} else {
+		revoked, err = h.bulkRevokeByFilter(request.Group, nullTime(request.CreatedFrom), nullTime(request.CreatedTo), request.keyAction)
+	}
+	if err != nil {
+		h.logger.Error("handler: BulkRevokeKeys", "Failed to revoke keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int64{"revoked_count": revoked})
+}
+
+// отзыв и запись истории одним запросом: $1..$6 - статусы, время, автор, причина и
+// действие, параметры фильтра начинаются с $7
+const bulkRevokeQuery = `WITH revoked AS (
+		UPDATE keys SET status = $1, revoked_at = $3, revoked_by = $4, revoke_reason = $5
+		WHERE status = $2 AND %s
+		RETURNING id
+	)
+	INSERT INTO key_events (key_id, action, reason, actor)
+	SELECT id, $6, $5, $4 FROM revoked`
+
+func (h *Handler) bulkRevokeByKeys(keys []string, action keyAction) (int64, error) {
+	keys, err := h.resolveKeys(keys)
+	if err != nil {
+		return 0, err
+	}
+	hashes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if hash := h.hashKey(key); hash.Valid {
+			hashes = append(hashes, hash.String)
+		}
+	}
+	return h.execBulkRevoke(fmt.Sprintf(bulkRevokeQuery, "(key_value = ANY($7) OR key_hash = ANY($8))"), action, keys, hashes)
+}
+
+func (h *Handler) bulkRevokeByFilter(groupName string, createdFrom, createdTo sql.NullTime, action keyAction) (int64, error) {
+	return h.execBulkRevoke(fmt.Sprintf(bulkRevokeQuery,
+		"group_name = $7 AND ($8::timestamptz IS NULL OR created_at >= $8) AND ($9::timestamptz IS NULL OR created_at < $9)"),
+		action, groupName, createdFrom, createdTo)
+}
+
+func (h *Handler) execBulkRevoke(query string, action keyAction, filterArgs ...any) (int64, error) {
+	args := append([]any{keyStatusRevoked, keyStatusActive, time.Now(), action.Actor, action.Reason, keyEventRevoke}, filterArgs...)
+	result, err := h.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -23,12 +23,44 @@ func NewRouter(h *Handler) http.Handler {
 
 	// Проверка бд подключения
 	r.Get("/ping", h.PingDatabaseHandler)
+	// счётчики для Prometheus
+	r.Get("/metrics", h.MetricsHandler)
 
 	// Получение групп, генерация UUID и т.п.
 	r.Route("/api", func(api chi.Router) {
-		api.Post("/keys/generate", h.GenerateKeysHandler)
+		// изменяющие запросы можно безопасно повторять с заголовком Idempotency-Key
+		idempotent := api.With(h.idempotencyMiddleware)
+
+		idempotent.Post("/keys/generate", h.GenerateKeysHandler)
 		api.Post("/keys/validate", h.ValidateKeyHandler)
+		// проверка подписи без обращения к базе, см. signing.go
+		api.Post("/keys/verify", h.VerifyKeysHandler)
+		idempotent.Post("/keys/redeem", h.RedeemKeyHandler)
+		idempotent.Post("/keys/revoke", h.BulkRevokeKeysHandler)
+		// импорт не буферизуется ради Idempotency-Key: повтор файла и так даёт только отчёт о дублях
+		api.Post("/keys/import", h.ImportKeysHandler)
+		idempotent.Post("/keys/{key}/revoke", h.RevokeKeyHandler)
+		idempotent.Post("/keys/{key}/reinstate", h.ReinstateKeyHandler)
+		api.Get("/keys", h.ListKeysHandler)
+		api.Get("/keys/export", h.ExportKeysHandler)
+		api.Get("/keys/{key}", h.GetKeyHandler)
+		api.Get("/ids/{kind}", h.GenerateIDsHandler)
+		api.Get("/signing-keys", h.GetSigningKeysHandler)
+		api.Post("/signing-keys/reload", h.ReloadSigningKeysHandler)
 		api.Get("/groups", h.GetGroupsHandler)
+		api.Get("/groups/{name}/capacity", h.GetGroupCapacityHandler)
+		idempotent.Post("/groups", h.CreateGroupHandler)
+		idempotent.Put("/groups/{name}", h.UpdateGroupHandler)
+		idempotent.Delete("/groups/{name}", h.DeleteGroupHandler)
+		api.Get("/batches", h.ListBatchesHandler)
+		api.Get("/batches/{id}", h.GetBatchHandler)
+		api.Get("/batches/{id}/export", h.ExportBatchHandler)
+		idempotent.Post("/batches/{id}/revoke", h.RevokeBatchHandler)
+		api.Get("/denylist", h.GetDenylistHandler)
+		idempotent.Put("/denylist", h.UpdateDenylistHandler)
+		api.Post("/denylist/reload", h.ReloadDenylistHandler)
+		api.Get("/jobs/{id}", h.GetJobHandler)
+		api.Get("/jobs/{id}/keys", h.GetJobKeysHandler)
 	})
 	return r
 }
@@ -49,6 +81,13 @@ func (r *responseRecorder) Write(b []byte) (int, error) {
 	return r.ResponseWriter.Write(b)
 }
 
+// Flush нужен потоковым ответам (выгрузка ключей), чтобы данные уходили клиенту сразу
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func (h *Handler) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
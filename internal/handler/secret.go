@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+)
+
+// Ключи секретных групп (api_key, user_token и т.п.) не хранятся в открытом виде:
+// в keys пишется только HMAC-SHA256 с серверным перцем и короткий префикс для
+// отображения. Полный ключ клиент видит один раз - в ответе на генерацию.
+// Группа может стать секретной и после выпуска ключей: её открытые ключи тогда
+// хешируются в той же транзакции, что и PUT. Обратно секретную группу с хешированными
+// ключами сделать нельзя - открытых значений больше нет.
+
+// максимум открытых случайных символов в префиксе
+const maxDisplayRandomChars = 4
+
+var (
+	errSecretNotConfigured = errors.New("secret groups are not configured")
+	errSecretHasHashedKeys = errors.New("group has hashed keys")
+)
+
+// hashKey считает HMAC ключа; пустой перец означает, что секретные группы не настроены
+func (h *Handler) hashKey(key string) sql.NullString {
+	if h.cfg.KeyPepper == "" {
+		return sql.NullString{}
+	}
+	mac := hmac.New(sha256.New, []byte(h.cfg.KeyPepper))
+	mac.Write([]byte(key))
+	return sql.NullString{String: hex.EncodeToString(mac.Sum(nil)), Valid: true}
+}
+
+// displayPrefix оставляет литералы шаблона и не больше четверти случайных символов
+// (но не больше maxDisplayRandomChars), чтобы префикс не снижал стойкость ключа
+func displayPrefix(key string, pattern *keygen.Pattern) string {
+	placeholders := 0
+	for _, token := range pattern.Tokens {
+		if token.IsPlaceholder() {
+			placeholders++
+		}
+	}
+	reveal := min(placeholders/4, maxDisplayRandomChars)
+
+	for i, token := range pattern.Tokens {
+		if token.IsPlaceholder() {
+			if reveal == 0 {
+				return key[:i]
+			}
+			reveal--
+		}
+	}
+	return key[:len(pattern.Tokens)]
+}
+
+// changeSecret переводит ключи группы при смене secret; вызывается под FOR UPDATE группы
+func (h *Handler) changeSecret(tx *sql.Tx, g *group, secret bool) error {
+	if !secret {
+		var hashed bool
+		err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM keys WHERE group_name = $1 AND key_hash IS NOT NULL)", g.Name).Scan(&hashed)
+		if err != nil {
+			return err
+		}
+		if hashed {
+			return errSecretHasHashedKeys
+		}
+		return nil
+	}
+	if h.cfg.KeyPepper == "" {
+		return errSecretNotConfigured
+	}
+	_, err := h.hashPlaintextKeys(tx, g)
+	return err
+}
+
+// hashPlaintextKeys заменяет открытые ключи группы на HMAC и префикс для отображения
+func (h *Handler) hashPlaintextKeys(tx *sql.Tx, g *group) (int, error) {
+	pattern, err := g.keyPattern()
+	if err != nil {
+		return 0, err
+	}
+	// база подписанного ключа в открытом виде выдала бы и сам ключ
+	secretGroup := *g
+	secretGroup.Secret = true
+	total := 0
+	for {
+		rows, err := tx.Query("SELECT id, key_value FROM keys WHERE group_name = $1 AND key_value IS NOT NULL LIMIT $2",
+			g.Name, insertBatchSize)
+		if err != nil {
+			return total, err
+		}
+		var (
+			keyIDs   []int64
+			hashes   []string
+			prefixes []string
+			bases    []string
+		)
+		for rows.Next() {
+			var (
+				id  int64
+				key string
+			)
+			if err := rows.Scan(&id, &key); err != nil {
+				rows.Close()
+				return total, err
+			}
+			keyIDs = append(keyIDs, id)
+			hashes = append(hashes, h.hashKey(key).String)
+			// ключ мог быть выпущен по прежнему шаблону группы, тогда префикс не показываем
+			prefix := ""
+			if len(key) >= len(pattern.Tokens) {
+				prefix = displayPrefix(key, pattern)
+			}
+			prefixes = append(prefixes, prefix)
+			bases = append(bases, h.keyBase(&secretGroup, key).String)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(keyIDs) == 0 {
+			return total, nil
+		}
+
+		_, err = tx.Exec(`UPDATE keys SET key_hash = c.hash, key_prefix = c.prefix, key_value = NULL,
+				key_base = CASE WHEN keys.key_base IS NULL THEN NULL ELSE NULLIF(c.base, '') END
+			FROM unnest($1::bigint[], $2::text[], $3::text[], $4::text[]) AS c(id, hash, prefix, base)
+			WHERE keys.id = c.id`, keyIDs, hashes, prefixes, bases)
+		if err != nil {
+			return total, err
+		}
+		total += len(keyIDs)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+)
+
+// Детерминированная генерация для тестовых фикстур: одна и та же группа, seed и
+// количество всегда дают одни и те же ключи (см. keygen.Seeded). Стоп-лист не
+// выбрасывает ключи из набора, иначе его правка меняла бы набор: если сохраняемый
+// ключ содержит запрещённое слово, отклоняется вся генерация и нужен другой seed.
+// Seed принимает только запрос с админским токеном, ключи с известным seed угадываемы.
+// Те же ключи без сервиса выпускает pkg/seeded.
+
+var (
+	errSeededKeysExist = errors.New("seeded keys already exist")
+	errSeededKeyDenied = errors.New("seeded keys contain denylisted words")
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+// isAdmin сверяет заголовок с AdminToken; пустой токен в конфиге отключает админские операции
+func (h *Handler) isAdmin(r *http.Request) bool {
+	token := r.Header.Get(adminTokenHeader)
+	return h.cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) == 1
+}
+
+// insertSeededKeys вставляет ровно ключи из seed: если какой-то уже выпущен или
+// попал в стоп-лист, набор не совпал бы с ожидаемым, поэтому вся генерация отменяется
+func (h *Handler) insertSeededKeys(tx *sql.Tx, g *group, pattern *keygen.Pattern, count int, opts keyInsertOptions) ([]string, error) {
+	candidates, err := keygen.Seeded(g.Name, pattern, opts.seed, count)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range candidates {
+		if !h.denylist.allows(key, pattern) {
+			h.denylist.rejected.Add(1)
+			return nil, errSeededKeyDenied
+		}
+	}
+	keys := make([]string, 0, count)
+	createdAt := time.Now()
+	for start := 0; start < len(candidates); start += insertBatchSize {
+		chunk := candidates[start:min(start+insertBatchSize, len(candidates))]
+		inserted, err := h.insertKeyBatch(tx, g, pattern, chunk, createdAt, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(inserted) < len(chunk) {
+			return nil, errSeededKeysExist
+		}
+		keys = append(keys, inserted...)
+	}
+	return keys, nil
+}
+
+// dryRunKeys выпускает ключи так же, как генерация, но ничего не пишет в базу:
+// не проверяет совпадения с уже выпущенными и не сдвигает счётчик режима permuted
+func (h *Handler) dryRunKeys(g *group, pattern *keygen.Pattern, count int, seed string) ([]string, error) {
+	var (
+		keys []string
+		err  error
+	)
+	switch {
+	case seed != "":
+		keys, err = keygen.Seeded(g.Name, pattern, seed, count)
+	case pattern.Permutation != nil:
+		keys, err = h.previewPermutedKeys(g, pattern, count)
+	default:
+		keys, err = h.previewRandomKeys(pattern, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.signKeys(g, keys)
+}
+
+// previewPermutedKeys - ключи, которые выдадут следующие номера счётчика группы.
+// Это настоящие будущие ключи, поэтому запрос без админского токена сюда не доходит.
+func (h *Handler) previewPermutedKeys(g *group, pattern *keygen.Pattern, count int) ([]string, error) {
+	keys := make([]string, 0, count)
+	for index := g.KeyCounter; len(keys) < count; index++ {
+		number := big.NewInt(index)
+		if number.Cmp(pattern.Permutation.Domain()) >= 0 {
+			return nil, keygen.ErrKeyspaceExhausted
+		}
+		key := pattern.KeyAt(pattern.Permutation.Apply(number))
+		if h.denylist.allows(key, pattern) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (h *Handler) previewRandomKeys(pattern *keygen.Pattern, count int) ([]string, error) {
+	source := keygen.NewCryptoSource()
+	keys := make([]string, 0, count)
+	seen := make(map[string]struct{}, count)
+	for attempts := 0; len(keys) < count; attempts++ {
+		if attempts >= count*keygen.CandidateAttemptsFactor {
+			return nil, keygen.ErrKeyspaceExhausted
+		}
+		key, ok := h.nextKey(pattern, source)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
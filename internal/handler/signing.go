@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/keysign"
+)
+
+// Ключи групп с подписью (partner, api_key) партнёры проверяют у себя без обращения
+// к сервису, см. pkg/keysign. Ключи подписи лежат в файле и держатся в памяти, поэтому
+// /api/keys/verify тоже не ходит в базу. Ротация: новый ключ группы дописывается в
+// конец файла, затем POST /api/signing-keys/reload; старые ключи остаются для проверки.
+
+const (
+	reasonBadSignature = "bad_signature"
+	reasonUnknownKeyID = "unknown_key_id"
+
+	maxVerifyKeys = 10000
+)
+
+var errBadSignatureAlgorithm = fmt.Errorf("signature must be %s or %s", keysign.AlgorithmHMAC, keysign.AlgorithmEd25519)
+
+type signingKeys struct {
+	path string
+
+	mu   sync.RWMutex
+	ring *keysign.Keyring
+}
+
+// newSigningKeys загружает ключи подписи из файла; отсутствие файла - пустой набор
+func newSigningKeys(path string) (*signingKeys, error) {
+	s := &signingKeys{path: path}
+	s.ring, _ = keysign.NewKeyring(nil)
+	if path == "" {
+		return s, nil
+	}
+	if err := s.reload(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return s, err
+	}
+	return s, nil
+}
+
+func (s *signingKeys) reload() error {
+	ring, err := keysign.LoadKeyring(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ring = ring
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *signingKeys) get() *keysign.Keyring {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring
+}
+
+// signingKey возвращает ключ, которым подписываются новые ключи группы
+func (h *Handler) signingKey(g *group) (*keysign.Key, error) {
+	return keygen.ActiveSigningKey(h.signing.get(), g.Name, g.Signature)
+}
+
+// signKeys подписывает ключи группы с подписью, остальные возвращает как есть
+func (h *Handler) signKeys(g *group, keys []string) ([]string, error) {
+	return keygen.Sign(h.signing.get(), g.Name, g.Signature, keys)
+}
+
+// keyBase - значение keys.key_base для ключа группы с подписью: исходный ключ без
+// подписи, у секретных групп - его HMAC. Ключи подписи ротируются, поэтому уникальность
+// подписанных ключей держится на базе, а не на полной строке
+func (h *Handler) keyBase(g *group, key string) sql.NullString {
+	if g.Signature == "" {
+		return sql.NullString{}
+	}
+	base, _, _, err := keysign.Split(key)
+	if err != nil {
+		return sql.NullString{}
+	}
+	if g.Secret {
+		return h.hashKey(base)
+	}
+	return sql.NullString{String: base, Valid: true}
+}
+
+// validKeyFormat проверяет ключ по шаблону, а у групп с подписью - ещё и подпись
+func (h *Handler) validKeyFormat(g *group, pattern *keygen.Pattern, key string) bool {
+	if g.Signature == "" {
+		return pattern.Valid(key)
+	}
+	base, k, err := h.signing.get().Verify(key)
+	if err != nil || k.Group != g.Name || k.Algorithm != g.Signature {
+		return false
+	}
+	return pattern.Valid(base)
+}
+
+// VerifyKeysHandler проверяет подписи ключей только по ключам подписи в памяти:
+// ни шаблон группы, ни статус ключа не проверяются
+func (h *Handler) VerifyKeysHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Keys []string `json:"keys"`
+		// если задана, ключ должен быть подписан ключом этой группы
+		Group string `json:"group"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
+		return
+	}
+	if len(request.Keys) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Keys array is empty"})
+		return
+	}
+	if len(request.Keys) > maxVerifyKeys {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("At most %d keys can be verified at once", maxVerifyKeys)})
+		return
+	}
+
+	type KeyVerification struct {
+		Key       string `json:"key"`
+		Valid     bool   `json:"valid"`
+		Reason    string `json:"reason"`
+		Group     string `json:"group,omitempty"`
+		KeyID     string `json:"key_id,omitempty"`
+		Algorithm string `json:"algorithm,omitempty"`
+	}
+
+	ring := h.signing.get()
+	results := make([]KeyVerification, 0, len(request.Keys))
+	for _, key := range request.Keys {
+		result := KeyVerification{Key: key, Reason: reasonOK}
+		_, k, err := ring.Verify(key)
+		switch {
+		case errors.Is(err, keysign.ErrUnknownKeyID):
+			result.Reason = reasonUnknownKeyID
+		case errors.Is(err, keysign.ErrBadSignature):
+			result.Reason = reasonBadSignature
+		case err != nil:
+			result.Reason = reasonBadFormat
+		case request.Group != "" && k.Group != request.Group:
+			result.Reason = reasonWrongGroup
+		}
+		if k != nil {
+			result.Group, result.KeyID, result.Algorithm = k.Group, k.ID, k.Algorithm
+		}
+		result.Valid = result.Reason == reasonOK
+		results = append(results, result)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"results": results})
+}
+
+// GetSigningKeysHandler отдаёт ключи подписи без секретов: партнёрам с Ed25519
+// этого достаточно для проверки
+func (h *Handler) GetSigningKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]keysign.Key{"keys": h.signing.get().Keys()})
+}
+
+// ReloadSigningKeysHandler перечитывает файл ключей подписи после ротации
+func (h *Handler) ReloadSigningKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if h.signing.path == "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Signing keys file is not configured"})
+		return
+	}
+	if err := h.signing.reload(); err != nil {
+		h.logger.Error("handler: ReloadSigningKeys", "Failed to reload signing keys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to reload signing keys: " + err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]keysign.Key{"keys": h.signing.get().Keys()})
+}
@@ -0,0 +1,112 @@
+package keygen
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/ids"
+)
+
+// Алфавит группы задаёт символы для позиции X в шаблоне. Кроме стандартного A-Z0-9
+// есть готовые наборы без похожих друг на друга символов, которые проще переписать
+// с печатного купона, либо можно передать свою строку символов.
+
+const (
+	alphabetDefault     = ""
+	alphabetCrockford   = "crockford"
+	alphabetUnambiguous = "unambiguous"
+	alphabetHex         = "hex"
+
+	minCustomAlphabetLength = 2
+	maxCustomAlphabetLength = 100
+)
+
+var namedAlphabets = map[string]string{
+	alphabetDefault: charsetAlphaNumeric,
+	// Crockford base32: без I, L, O и U
+	alphabetCrockford: "0123456789ABCDEFGHJKMNPQRSTVWXYZ",
+	// без 0/O и 1/I/L
+	alphabetUnambiguous: "23456789ABCDEFGHJKMNPQRSTUVWXYZ",
+	alphabetHex:         "0123456789ABCDEF",
+}
+
+// группы символов, которые путают при ручном вводе
+var lookalikes = []string{"0O", "1IL"}
+
+var errBadAlphabet = errors.New("alphabet must be crockford, unambiguous, hex or a string of distinct printable ASCII characters")
+
+// ResolveAlphabet возвращает набор символов по имени алфавита или своей строке
+func ResolveAlphabet(alphabet string) (string, error) {
+	if charset, ok := namedAlphabets[alphabet]; ok {
+		return charset, nil
+	}
+	if len(alphabet) < minCustomAlphabetLength || len(alphabet) > maxCustomAlphabetLength {
+		return "", fmt.Errorf("%w: custom alphabet must have %d to %d characters", errBadAlphabet,
+			minCustomAlphabetLength, maxCustomAlphabetLength)
+	}
+	for i := 0; i < len(alphabet); i++ {
+		if alphabet[i] <= 0x20 || alphabet[i] > 0x7e {
+			return "", errBadAlphabet
+		}
+		if strings.IndexByte(alphabet[:i], alphabet[i]) >= 0 {
+			return "", fmt.Errorf("%w: %q repeats", errBadAlphabet, alphabet[i])
+		}
+	}
+	return alphabet, nil
+}
+
+// NormalizeKey приводит введённый вручную ключ к виду, в котором он выпущен: если
+// символ не подходит позиции шаблона, пробуются другой регистр и похожие символы
+// (O -> 0, I/L -> 1 и наоборот). Для шаблонов без нормализации меняются только
+// символы, которые проверка принимает сверх выпускаемых (строчные буквы на месте X
+// стандартного алфавита и в контрольном символе): они переводятся в верхний регистр,
+// иначе ключ, признанный валидным по формату, не нашёлся бы в базе. У стандартных
+// идентификаторов приводится только регистр.
+func (p *Pattern) NormalizeKey(key string) string {
+	if p.Kind != "" {
+		return ids.Canonical(p.Kind, key)
+	}
+	if len(key) != p.KeyLength() {
+		return key
+	}
+
+	normalized := []byte(key)
+	for i := range normalized {
+		token := checksumToken
+		if i < len(p.Tokens) {
+			token = p.Tokens[i]
+		}
+		if p.Normalize {
+			normalized[i] = normalizeChar(normalized[i], token)
+		} else if !token.Issued(normalized[i]) && token.Matches(normalized[i]) {
+			normalized[i] = upperASCII(normalized[i])
+		}
+	}
+	return string(normalized)
+}
+
+func normalizeChar(char byte, token Token) byte {
+	if token.Issued(char) {
+		return char
+	}
+	candidates := []byte{upperASCII(char), lowerASCII(char)}
+	for _, group := range lookalikes {
+		if strings.IndexByte(group, upperASCII(char)) >= 0 {
+			candidates = append(candidates, group...)
+		}
+	}
+	for _, candidate := range candidates {
+		if token.Issued(candidate) {
+			return candidate
+		}
+	}
+	return char
+}
+
+func lowerASCII(char byte) byte {
+	if char >= 'A' && char <= 'Z' {
+		return char - 'A' + 'a'
+	}
+	return char
+}
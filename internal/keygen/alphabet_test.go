@@ -0,0 +1,105 @@
+package keygen
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/ids"
+)
+
+func TestResolveAlphabet(t *testing.T) {
+	tests := []struct {
+		alphabet string
+		want     string
+		err      error
+	}{
+		{alphabetDefault, charsetAlphaNumeric, nil},
+		{alphabetCrockford, "0123456789ABCDEFGHJKMNPQRSTVWXYZ", nil},
+		{alphabetUnambiguous, "23456789ABCDEFGHJKMNPQRSTUVWXYZ", nil},
+		{alphabetHex, "0123456789ABCDEF", nil},
+		{"AB", "AB", nil},
+		{"abc-_", "abc-_", nil},
+		{"x", "", errBadAlphabet},
+		{"ABCA", "", errBadAlphabet},
+		{"AB C", "", errBadAlphabet},
+		{"AB\tC", "", errBadAlphabet},
+		{"ABЖ", "", errBadAlphabet},
+		{"Crockford", "", errBadAlphabet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alphabet, func(t *testing.T) {
+			got, err := ResolveAlphabet(tt.alphabet)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ResolveAlphabet(%q) error = %v, want %v", tt.alphabet, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveAlphabet(%q) = %q, want %q", tt.alphabet, got, tt.want)
+			}
+		})
+	}
+
+	long := make([]byte, maxCustomAlphabetLength+1)
+	for i := range long {
+		long[i] = byte(0x21 + i%94)
+	}
+	if _, err := ResolveAlphabet(string(long)); !errors.Is(err, errBadAlphabet) {
+		t.Errorf("ResolveAlphabet(%d characters) error = %v, want %v", len(long), err, errBadAlphabet)
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		checksum  string
+		alphabet  string
+		normalize bool
+		key       string
+		want      string
+	}{
+		{"issued key is kept", "AV-X{4}", checksumNone, alphabetCrockford, true, "AV-0A1Z", "AV-0A1Z"},
+		{"lower case", "AV-X{4}", checksumNone, alphabetCrockford, true, "av-0a1z", "AV-0A1Z"},
+		{"lookalikes go to digits", "X{4}", checksumNone, alphabetCrockford, true, "OILo", "0110"},
+		{"digits go to letters", "@{3}", checksumNone, alphabetDefault, true, "01L", "OIL"},
+		{"literals are matched too", "AV-#{2}", checksumNone, alphabetDefault, true, "av-O1", "AV-01"},
+		// в unambiguous нет ни 0/O, ни 1/I/L - заменять не на что, символ остаётся
+		{"no lookalike target", "X{3}", checksumNone, alphabetUnambiguous, true, "O1A", "O1A"},
+		{"no target for one of the group", "X{2}", checksumNone, alphabetHex, true, "lo", "10"},
+		{"custom alphabet keeps case", "X{3}", checksumNone, "abcO", true, "ABo", "abO"},
+		// контрольный символ нормализуется по своему алфавиту: цифры и заглавные буквы
+		{"checksum position upper case", "#{4}", checksumLuhn, alphabetDefault, true, "1234y", "1234Y"},
+		{"checksum position keeps letter O", "#{4}", checksumLuhn, alphabetDefault, true, "1234o", "1234O"},
+		{"checksum position after lookalikes", "#{4}", checksumLuhn, alphabetDefault, true, "l2O4b", "1204B"},
+		{"wrong length is not touched", "#{4}", checksumLuhn, alphabetDefault, true, "l2O4", "l2O4"},
+		// без нормализации меняется только регистр того, что проверка и так принимает
+		{"lenient X without normalize", "AV-X{4}", checksumNone, alphabetDefault, false, "AV-ab1z", "AV-AB1Z"},
+		{"lenient checksum without normalize", "#{4}", checksumLuhn, alphabetDefault, false, "1234y", "1234Y"},
+		{"strict positions without normalize", "@{2}-%{2}", checksumNone, alphabetDefault, false, "ab-CD", "ab-CD"},
+		{"lookalikes without normalize", "#{4}", checksumNone, alphabetDefault, false, "O1L2", "O1L2"},
+		{"custom alphabet without normalize", "X{2}", checksumNone, "ABCD", false, "ab", "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.pattern, tt.checksum, tt.alphabet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Normalize = tt.normalize
+			if got := p.NormalizeKey(tt.key); got != tt.want {
+				t.Errorf("NormalizeKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeKeyOfIDs(t *testing.T) {
+	p, err := NewID(ids.UUIDv7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const key = "0190C4A2-7B1E-7C3D-8E4F-1A2B3C4D5E6F"
+	if got, want := p.NormalizeKey(key), strings.ToLower(key); got != want {
+		t.Errorf("NormalizeKey(%q) = %q, want %q", key, got, want)
+	}
+}
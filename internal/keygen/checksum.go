@@ -0,0 +1,118 @@
+package keygen
+
+import (
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// Контрольный символ добавляется в конец ключа и считается по всем буквам и цифрам
+// ключа (литералы шаблона тоже участвуют), регистр не важен. Остальные символы
+// вроде '-' пропускаются.
+const (
+	checksumNone = ""
+	checksumLuhn = "luhn"
+	checksumDamm = "damm"
+	checksumCRC  = "crc"
+)
+
+// алфавит контрольных символов и значений для Luhn mod N
+const checksumAlphabet = charsetDigits + charsetUpper
+
+// позиция контрольного символа: выпускается в верхнем регистре, проверяется без учёта регистра
+var checksumToken = Token{Charset: checksumAlphabet, Lenient: charsetLower}
+
+var errUnknownChecksum = errors.New("unknown checksum algorithm, expected luhn, damm or crc")
+
+// таблица квазигруппы для алгоритма Дамма
+var dammTable = [10][10]byte{
+	{0, 3, 1, 7, 5, 9, 8, 6, 4, 2},
+	{7, 0, 9, 2, 1, 5, 4, 8, 6, 3},
+	{4, 2, 0, 6, 8, 7, 1, 3, 5, 9},
+	{1, 7, 5, 0, 9, 8, 3, 4, 2, 6},
+	{6, 1, 2, 3, 0, 4, 5, 9, 7, 8},
+	{3, 6, 7, 4, 2, 0, 9, 5, 8, 1},
+	{5, 8, 6, 9, 7, 2, 0, 1, 3, 4},
+	{8, 9, 4, 5, 3, 6, 2, 0, 1, 7},
+	{9, 4, 3, 8, 6, 1, 7, 2, 0, 5},
+	{2, 5, 8, 1, 4, 3, 6, 7, 9, 0},
+}
+
+func validateChecksum(algorithm string) error {
+	switch algorithm {
+	case checksumNone, checksumLuhn, checksumDamm, checksumCRC:
+		return nil
+	}
+	return errUnknownChecksum
+}
+
+// checksumValues переводит буквы и цифры ключа в значения 0..35
+func checksumValues(body string) []int {
+	values := make([]int, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		if index := strings.IndexByte(checksumAlphabet, upperASCII(body[i])); index >= 0 {
+			values = append(values, index)
+		}
+	}
+	return values
+}
+
+func upperASCII(char byte) byte {
+	if char >= 'a' && char <= 'z' {
+		return char - 'a' + 'A'
+	}
+	return char
+}
+
+// computeChecksum возвращает контрольный символ для тела ключа
+func computeChecksum(algorithm, body string) byte {
+	switch algorithm {
+	case checksumLuhn:
+		return luhnModN(checksumValues(body))
+	case checksumDamm:
+		return damm(checksumValues(body))
+	case checksumCRC:
+		sum := crc32.ChecksumIEEE([]byte(strings.ToUpper(body)))
+		return checksumAlphabet[sum%uint32(len(checksumAlphabet))]
+	}
+	return 0
+}
+
+// verifyChecksum проверяет последний символ ключа
+func verifyChecksum(algorithm, key string) bool {
+	if key == "" {
+		return false
+	}
+	body := key[:len(key)-1]
+	return computeChecksum(algorithm, body) == upperASCII(key[len(key)-1])
+}
+
+// luhnModN - алгоритм Луна по основанию 36
+func luhnModN(values []int) byte {
+	n := len(checksumAlphabet)
+	factor := 2
+	sum := 0
+	for i := len(values) - 1; i >= 0; i-- {
+		addend := factor * values[i]
+		addend = addend/n + addend%n
+		sum += addend
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+	}
+	return checksumAlphabet[(n-sum%n)%n]
+}
+
+// damm работает с десятичными цифрами, поэтому каждое значение 0..35
+// подаётся как две цифры, а контрольный символ всегда цифра; из-за этого часть
+// замен и перестановок символов ключа не ловится, для буквенных ключей лучше luhn
+func damm(values []int) byte {
+	interim := byte(0)
+	for _, value := range values {
+		interim = dammTable[interim][value/10]
+		interim = dammTable[interim][value%10]
+	}
+	return charsetDigits[interim]
+}
@@ -0,0 +1,113 @@
+package keygen
+
+import "testing"
+
+func TestComputeChecksum(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		body      string
+		want      byte
+	}{
+		{checksumLuhn, "0", '0'},
+		{checksumLuhn, "1", 'Y'},
+		{checksumLuhn, "123456789", '2'},
+		{checksumLuhn, "ZZZZ", '4'},
+		{checksumLuhn, "AVITO-PROMO-ABCD", 'Q'},
+		// регистр и разделители не влияют
+		{checksumLuhn, "avito promo abcd", 'Q'},
+		// значения 0..35 подаются в Дамма двумя десятичными цифрами
+		{checksumDamm, "572", '9'},
+		{checksumDamm, "123456789", '0'},
+		{checksumDamm, "Z", '6'},
+		// проходит через строку 8 таблицы: 0, 3 -> 1, 0 -> 7, 8 -> 0
+		{checksumDamm, "38", '0'},
+		{checksumDamm, "AVITO-PROMO-ABCD", '1'},
+		// CRC-32 IEEE("123456789") = 0xCBF43926, 3421780262 mod 36 = 26
+		{checksumCRC, "123456789", 'Q'},
+		{checksumCRC, "abc", 'C'},
+		{checksumCRC, "A1B2", 'O'},
+		{checksumCRC, "AVITO-PROMO-ABCD", '0'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm+"/"+tt.body, func(t *testing.T) {
+			if got := computeChecksum(tt.algorithm, tt.body); got != tt.want {
+				t.Errorf("computeChecksum(%q, %q) = %q, want %q", tt.algorithm, tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		key       string
+		want      bool
+	}{
+		{checksumLuhn, "123456789" + "2", true},
+		{checksumLuhn, "123456789" + "3", false},
+		{checksumLuhn, "AVITO-PROMO-ABCDq", true},
+		{checksumDamm, "5729", true},
+		{checksumDamm, "5724", false},
+		{checksumCRC, "123456789Q", true},
+		{checksumCRC, "123456789q", true},
+		{checksumCRC, "123456789R", false},
+		{checksumLuhn, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm+"/"+tt.key, func(t *testing.T) {
+			if got := verifyChecksum(tt.algorithm, tt.key); got != tt.want {
+				t.Errorf("verifyChecksum(%q, %q) = %v, want %v", tt.algorithm, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+// Luhn mod N ловит любую замену одного символа тела ключа, Дамм - замену одной цифры
+// (буква подаётся в Дамма двумя цифрами, см. damm)
+func TestChecksumDetectsSubstitution(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		body      string
+		alphabet  string
+	}{
+		{checksumLuhn, "K9X2", checksumAlphabet},
+		{checksumLuhn, "PROMO7", checksumAlphabet},
+		{checksumDamm, "572", charsetDigits},
+		{checksumDamm, "1234567890", charsetDigits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm+"/"+tt.body, func(t *testing.T) {
+			key := tt.body + string(computeChecksum(tt.algorithm, tt.body))
+			for i := 0; i < len(tt.body); i++ {
+				for j := 0; j < len(tt.alphabet); j++ {
+					if tt.alphabet[j] == tt.body[i] {
+						continue
+					}
+					typo := key[:i] + string(tt.alphabet[j]) + key[i+1:]
+					if verifyChecksum(tt.algorithm, typo) {
+						t.Errorf("typo %q of %q passed", typo, key)
+					}
+				}
+			}
+		})
+	}
+}
+
+// таблица Дамма - квазигруппа с нулевой диагональью, на этом держится обнаружение ошибок
+func TestDammTable(t *testing.T) {
+	for i := range dammTable {
+		if dammTable[i][i] != 0 {
+			t.Errorf("dammTable[%d][%d] = %d, want 0", i, i, dammTable[i][i])
+		}
+		var row, column [10]bool
+		for j := range dammTable {
+			row[dammTable[i][j]] = true
+			column[dammTable[j][i]] = true
+		}
+		for value := range row {
+			if !row[value] || !column[value] {
+				t.Errorf("row or column %d misses %d", i, value)
+			}
+		}
+	}
+}
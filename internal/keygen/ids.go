@@ -0,0 +1,27 @@
+package keygen
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/ids"
+)
+
+// NewID - «шаблон» группы стандартных идентификаторов (см. pkg/ids): без позиций, только вид
+func NewID(kind string) (*Pattern, error) {
+	if _, ok := ids.Lookup(kind); !ok {
+		return nil, fmt.Errorf("%w: %q", ids.ErrUnknownKind, kind)
+	}
+	return &Pattern{Source: kind, Kind: kind}, nil
+}
+
+// kindInfo возвращает описание вида идентификатора шаблона
+func (p *Pattern) kindInfo() ids.Info {
+	info, _ := ids.Lookup(p.Kind)
+	return info
+}
+
+// kindKeyspaceSize - пространство значений идентификатора
+func (p *Pattern) kindKeyspaceSize() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(p.kindInfo().Bits))
+}
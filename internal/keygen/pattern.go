@@ -0,0 +1,243 @@
+// Package keygen - разбор шаблонов ключей и выпуск ключей по ним без базы и HTTP:
+// алфавиты, контрольные символы, источники случайности, перестановка режима permuted,
+// генерация из seed и подпись. Сервис (internal/handler) и pkg/seeded выпускают ключи
+// одним и тем же кодом, поэтому не могут разойтись между собой.
+package keygen
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/ids"
+)
+
+// Синтаксис шаблона:
+//
+//	X     - любой символ алфавита группы, по умолчанию A-Z или 0-9 (см. alphabet.go)
+//	#     - цифра 0-9
+//	@     - заглавная буква A-Z
+//	%     - строчная буква a-z
+//	\c    - символ c как есть (например \X, \#, \{, \\)
+//	{n}   - повторить предыдущий элемент n раз (XXXX == X{4})
+//	любой другой печатный ASCII символ - литерал
+//
+// Генерация и валидация ключей работают по одному разобранному шаблону,
+// поэтому не могут разойтись между собой.
+
+const (
+	// максимальная длина шаблона, совпадает с размером колонки groups.pattern
+	maxPatternLength = 100
+	// максимальная длина ключа после раскрытия повторов, совпадает с keys.key_value
+	MaxKeyLength = 255
+)
+
+const (
+	charsetAlphaNumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	charsetDigits       = "0123456789"
+	charsetUpper        = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	charsetLower        = "abcdefghijklmnopqrstuvwxyz"
+)
+
+var placeholderCharsets = map[byte]string{
+	'X': charsetAlphaNumeric,
+	'#': charsetDigits,
+	'@': charsetUpper,
+	'%': charsetLower,
+}
+
+var (
+	errEmptyPattern       = errors.New("pattern is empty")
+	errPatternTooLong     = errors.New("pattern is too long")
+	ErrKeyTooLong         = errors.New("pattern expands to a key longer than 255 characters")
+	errPatternNoVariables = errors.New("pattern has no placeholders")
+	errPatternBadChar     = errors.New("pattern contains non-printable or non-ASCII characters")
+	errPatternBadEscape   = errors.New("pattern ends with an unfinished escape")
+	errPatternBadRepeat   = errors.New("pattern has an invalid repeat count")
+)
+
+// Token - одна позиция ключа: либо литерал, либо набор допустимых символов
+type Token struct {
+	Literal byte
+	Charset string
+	// символы, которые проверка ключа принимает сверх Charset, см. Parse
+	Lenient string
+}
+
+// IsPlaceholder сообщает, случайная ли это позиция
+func (t Token) IsPlaceholder() bool {
+	return t.Charset != ""
+}
+
+// Issued сообщает, может ли символ быть выпущен на этой позиции
+func (t Token) Issued(char byte) bool {
+	if !t.IsPlaceholder() {
+		return char == t.Literal
+	}
+	return strings.IndexByte(t.Charset, char) >= 0
+}
+
+// Matches сообщает, принимает ли проверка ключа символ на этой позиции
+func (t Token) Matches(char byte) bool {
+	return t.Issued(char) || t.IsPlaceholder() && strings.IndexByte(t.Lenient, char) >= 0
+}
+
+// Pattern - разобранный шаблон группы вместе с настройками, от которых зависят ключи
+type Pattern struct {
+	Source string
+	Tokens []Token
+	// алгоритм контрольного символа в конце ключа, пустая строка - без него
+	Checksum string
+	// приводить ли введённые ключи к виду шаблона перед проверкой, см. NormalizeKey
+	Normalize bool
+	// перестановка номеров ключей для групп в режиме permuted, см. permutation.go
+	Permutation *Permutation
+	// вид стандартного идентификатора вместо позиций шаблона, см. ids.go
+	Kind string
+}
+
+// KeyLength возвращает длину ключа вместе с контрольным символом
+func (p *Pattern) KeyLength() int {
+	if p.Kind != "" {
+		return p.kindInfo().Length
+	}
+	if p.Checksum != checksumNone {
+		return len(p.Tokens) + 1
+	}
+	return len(p.Tokens)
+}
+
+// Parse разбирает шаблон в последовательность позиций ключа,
+// alphabet - набор символов для позиции X
+func Parse(pattern, alphabet string) (*Pattern, error) {
+	if pattern == "" {
+		return nil, errEmptyPattern
+	}
+	if len(pattern) > maxPatternLength {
+		return nil, errPatternTooLong
+	}
+
+	p := &Pattern{Source: pattern}
+	for i := 0; i < len(pattern); i++ {
+		char := pattern[i]
+		if char < 0x20 || char > 0x7e {
+			return nil, errPatternBadChar
+		}
+
+		switch {
+		case char == '\\':
+			i++
+			if i >= len(pattern) {
+				return nil, errPatternBadEscape
+			}
+			if pattern[i] < 0x20 || pattern[i] > 0x7e {
+				return nil, errPatternBadChar
+			}
+			p.Tokens = append(p.Tokens, Token{Literal: pattern[i]})
+		case char == '{':
+			end := i + 1
+			for end < len(pattern) && pattern[end] != '}' {
+				end++
+			}
+			if end >= len(pattern) || len(p.Tokens) == 0 {
+				return nil, errPatternBadRepeat
+			}
+			count, err := strconv.Atoi(pattern[i+1 : end])
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("%w: %q", errPatternBadRepeat, pattern[i:end+1])
+			}
+			if len(p.Tokens)+count-1 > MaxKeyLength {
+				return nil, ErrKeyTooLong
+			}
+			last := p.Tokens[len(p.Tokens)-1]
+			for j := 1; j < count; j++ {
+				p.Tokens = append(p.Tokens, last)
+			}
+			i = end
+		case char == 'X':
+			// раньше проверка принимала на месте X любую букву или цифру, хотя выпускались
+			// только A-Z0-9; стандартный алфавит сохраняет это, чтобы ключи, которые
+			// клиенты уже проверяют в нижнем регистре, не стали невалидными
+			token := Token{Charset: alphabet}
+			if alphabet == charsetAlphaNumeric {
+				token.Lenient = charsetLower
+			}
+			p.Tokens = append(p.Tokens, token)
+		case placeholderCharsets[char] != "":
+			p.Tokens = append(p.Tokens, Token{Charset: placeholderCharsets[char]})
+		default:
+			p.Tokens = append(p.Tokens, Token{Literal: char})
+		}
+
+		if len(p.Tokens) > MaxKeyLength {
+			return nil, ErrKeyTooLong
+		}
+	}
+	return p, nil
+}
+
+// New разбирает шаблон группы вместе с настройками контрольного символа и алфавита
+func New(pattern, checksum, alphabet string) (*Pattern, error) {
+	if err := validateChecksum(checksum); err != nil {
+		return nil, err
+	}
+	charset, err := ResolveAlphabet(alphabet)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(pattern, charset)
+	if err != nil {
+		return nil, err
+	}
+	p.Checksum = checksum
+	if p.KeyLength() > MaxKeyLength {
+		return nil, ErrKeyTooLong
+	}
+	return p, nil
+}
+
+// Validate проверяет, что в шаблоне есть хотя бы одна случайная позиция
+func (p *Pattern) Validate() error {
+	for _, token := range p.Tokens {
+		if token.IsPlaceholder() {
+			return nil
+		}
+	}
+	return errPatternNoVariables
+}
+
+// Valid проверяет ключ по шаблону и контрольному символу
+func (p *Pattern) Valid(key string) bool {
+	if p.Kind != "" {
+		return ids.Valid(p.Kind, key)
+	}
+	if len(key) != p.KeyLength() {
+		return false
+	}
+
+	for i, token := range p.Tokens {
+		if !token.Matches(key[i]) {
+			return false
+		}
+	}
+	if p.Checksum != checksumNone {
+		return verifyChecksum(p.Checksum, key)
+	}
+	return true
+}
+
+// KeyspaceSize - произведение размеров наборов символов всех случайных позиций
+func (p *Pattern) KeyspaceSize() *big.Int {
+	if p.Kind != "" {
+		return p.kindKeyspaceSize()
+	}
+	size := big.NewInt(1)
+	for _, token := range p.Tokens {
+		if token.IsPlaceholder() {
+			size.Mul(size, big.NewInt(int64(len(token.Charset))))
+		}
+	}
+	return size
+}
@@ -0,0 +1,176 @@
+package keygen
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		pattern string
+		// ожидаемые позиции: литерал как есть, случайная позиция как её плейсхолдер
+		want string
+	}{
+		{"X", "X"},
+		{"#@%", "#@%"},
+		{"AVITO-XXXX", "AVITO-XXXX"},
+		{"X{4}", "XXXX"},
+		{"#{3}-@{2}", "###-@@"},
+		// после \ любой символ - литерал, в том числе плейсхолдеры и скобки
+		{`\X\#\@\%`, `\X\#\@\%`},
+		{`\{X\}`, `\{X\}`},
+		{`A\\B`, `A\\B`},
+		// повтор литерала и экранированного символа
+		{"-{3}X", "---X"},
+		{`\X{2}`, `\X\X`},
+		{"X{1}", "X"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			p, err := Parse(tt.pattern, charsetAlphaNumeric)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.pattern, err)
+			}
+			if got := describeTokens(p.Tokens); got != tt.want {
+				t.Errorf("Parse(%q) tokens = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+// describeTokens записывает позиции обратно в синтаксис шаблона без повторов
+func describeTokens(tokens []Token) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		if !token.IsPlaceholder() {
+			if _, ok := placeholderCharsets[token.Literal]; ok || strings.IndexByte(`\{}`, token.Literal) >= 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(token.Literal)
+			continue
+		}
+		for placeholder, charset := range placeholderCharsets {
+			if charset == token.Charset {
+				b.WriteByte(placeholder)
+			}
+		}
+	}
+	return b.String()
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    error
+	}{
+		{"empty", "", errEmptyPattern},
+		{"too long", strings.Repeat("X", maxPatternLength+1), errPatternTooLong},
+		{"non-ASCII", "KEY-Ж", errPatternBadChar},
+		{"control character", "KEY\tX", errPatternBadChar},
+		{"trailing backslash", `XXXX\`, errPatternBadEscape},
+		{"lone backslash", `\`, errPatternBadEscape},
+		{"escaped non-printable", "X\\\n", errPatternBadChar},
+		{"repeat without element", "{3}X", errPatternBadRepeat},
+		{"only braces", "{}", errPatternBadRepeat},
+		{"unclosed repeat", "X{3", errPatternBadRepeat},
+		{"empty repeat", "X{}", errPatternBadRepeat},
+		{"zero repeat", "X{0}", errPatternBadRepeat},
+		{"negative repeat", "X{-1}", errPatternBadRepeat},
+		{"non-numeric repeat", "X{a}", errPatternBadRepeat},
+		{"repeat over key length", "X{256}", ErrKeyTooLong},
+		{"repeats add up over key length", "X{200}#{56}", ErrKeyTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.pattern, charsetAlphaNumeric); !errors.Is(err, tt.want) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.pattern, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for pattern, want := range map[string]error{
+		"AVITO-X": nil,
+		"AVITO":   errPatternNoVariables,
+		`\X\#`:    errPatternNoVariables,
+	} {
+		p, err := Parse(pattern, charsetAlphaNumeric)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", pattern, err)
+		}
+		if err := p.Validate(); !errors.Is(err, want) {
+			t.Errorf("Validate(%q) = %v, want %v", pattern, err, want)
+		}
+	}
+}
+
+// ключ, выпущенный по шаблону, проходит проверку по тому же шаблону, а ключ с
+// символом не из набора позиции - нет
+func TestDrawnKeysAreValid(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		checksum string
+		// символ, которого нет в наборе случайных позиций шаблона
+		foreign byte
+	}{
+		{"X{8}", checksumNone, '-'},
+		{"#{8}", checksumNone, 'A'},
+		{"@{8}", checksumNone, 'a'},
+		{"%{8}", checksumNone, 'A'},
+		{"AVITO-X{4}-#{2}@%", checksumNone, '_'},
+		{"#{8}", checksumLuhn, 'A'},
+		{"@{6}", checksumDamm, 'a'},
+		{"%{6}", checksumCRC, '1'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.checksum, func(t *testing.T) {
+			p, err := New(tt.pattern, tt.checksum, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := len(p.Tokens) - 1
+			for !p.Tokens[last].IsPlaceholder() {
+				last--
+			}
+			source := NewSource(bytes.NewReader(bytes.Repeat([]byte{0, 37, 91, 130, 200, 255, 13, 64}, 64)))
+			for i := 0; i < 20; i++ {
+				key := p.Draw(source)
+				if len(key) != p.KeyLength() {
+					t.Fatalf("Draw() = %q, length %d, want %d", key, len(key), p.KeyLength())
+				}
+				if !p.Valid(key) {
+					t.Fatalf("Valid(%q) = false for a drawn key", key)
+				}
+
+				broken := []byte(key)
+				broken[last] = tt.foreign
+				if p.Valid(string(broken)) {
+					t.Fatalf("Valid(%q) = true with %q on position %d", broken, tt.foreign, last)
+				}
+			}
+		})
+	}
+}
+
+// в стандартном алфавите X проверка принимает строчные буквы, хотя выпускает заглавные
+func TestDefaultAlphabetLowercaseIsLenient(t *testing.T) {
+	p, err := New("X{4}", checksumNone, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Valid("ab1z") {
+		t.Error(`Valid("ab1z") = false, want true for the default alphabet`)
+	}
+
+	p, err = New("X{4}", checksumNone, alphabetHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Valid("ab1f") {
+		t.Error(`Valid("ab1f") = true, want false for the hex alphabet`)
+	}
+}
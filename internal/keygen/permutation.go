@@ -0,0 +1,105 @@
+package keygen
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+)
+
+const (
+	feistelRounds = 8
+	// длина ключа перестановки, см. NewPermutationKey
+	permutationKeyLength = 32
+)
+
+// Permutation - биекция на [0, domain): сеть Фейстеля на 2*halfBits битах
+// с «прогулкой по циклу», пока значение не попадёт в domain
+type Permutation struct {
+	key      []byte
+	domain   *big.Int
+	halfBits uint
+	mask     *big.Int
+}
+
+// NewPermutation строит перестановку [0, domain) по секретному ключу группы
+func NewPermutation(key []byte, domain *big.Int) *Permutation {
+	bits := uint(new(big.Int).Sub(domain, big.NewInt(1)).BitLen())
+	halfBits := max((bits+1)/2, 1)
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), halfBits), big.NewInt(1))
+	return &Permutation{key: key, domain: domain, halfBits: halfBits, mask: mask}
+}
+
+// Domain - размер пространства, на котором действует перестановка
+func (p *Permutation) Domain() *big.Int {
+	return p.domain
+}
+
+// NewPermutationKey выдаёт случайный ключ перестановки для новой группы
+func NewPermutationKey() []byte {
+	key := make([]byte, permutationKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		panic("random source failed: " + err.Error())
+	}
+	return key
+}
+
+// Apply переставляет x из [0, domain). Сеть Фейстеля - биекция на 2^(2*halfBits)
+// значениях, поэтому повторное применение из-за пределов domain рано или поздно
+// вернёт в него; в среднем нужно не больше четырёх проходов.
+func (p *Permutation) Apply(x *big.Int) *big.Int {
+	x = new(big.Int).Set(x)
+	for {
+		x = p.feistel(x)
+		if x.Cmp(p.domain) < 0 {
+			return x
+		}
+	}
+}
+
+func (p *Permutation) feistel(x *big.Int) *big.Int {
+	left := new(big.Int).Rsh(x, p.halfBits)
+	right := new(big.Int).And(x, p.mask)
+	for round := 0; round < feistelRounds; round++ {
+		left, right = right, left.Xor(left, p.round(round, right))
+	}
+	return left.Lsh(left, p.halfBits).Or(left, right)
+}
+
+// round - функция раунда: HMAC-SHA256 от номера раунда и правой половины,
+// растянутый до halfBits счётчиком блоков
+func (p *Permutation) round(round int, right *big.Int) *big.Int {
+	size := int(p.halfBits+7) / 8
+	input := right.FillBytes(make([]byte, size))
+
+	out := make([]byte, 0, size+sha256.Size)
+	for block := byte(0); len(out) < size; block++ {
+		mac := hmac.New(sha256.New, p.key)
+		mac.Write([]byte{byte(round), block})
+		mac.Write(input)
+		out = mac.Sum(out)
+	}
+	value := new(big.Int).SetBytes(out[:size])
+	return value.And(value, p.mask)
+}
+
+// KeyAt строит ключ по его индексу в пространстве шаблона: индекс раскладывается
+// по случайным позициям как число в смешанной системе счисления
+func (p *Pattern) KeyAt(index *big.Int) string {
+	key := make([]byte, len(p.Tokens), p.KeyLength())
+	rest := new(big.Int).Set(index)
+	digit := new(big.Int)
+	for i := len(p.Tokens) - 1; i >= 0; i-- {
+		token := p.Tokens[i]
+		if !token.IsPlaceholder() {
+			key[i] = token.Literal
+			continue
+		}
+		rest.DivMod(rest, big.NewInt(int64(len(token.Charset))), digit)
+		key[i] = token.Charset[digit.Int64()]
+	}
+	if p.Checksum != checksumNone {
+		key = append(key, computeChecksum(p.Checksum, string(key)))
+	}
+	return string(key)
+}
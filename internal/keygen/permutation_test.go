@@ -0,0 +1,104 @@
+package keygen
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// на малых доменах перестановка проверяется целиком: каждый номер из [0, domain)
+// переходит в значение из [0, domain), и все значения разные
+func TestKeyPermutationIsBijection(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain int64
+	}{
+		{"one", 1},
+		{"two", 2},
+		{"power of two", 64},
+		{"odd square", 81},
+		// 2^(2*halfBits) = 1024, почти половина значений вне домена - прогулка по циклу
+		{"walks out of domain", 513},
+		{"prime", 1009},
+		{"pattern XX", 36 * 36},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPermutation(bytes.Repeat([]byte{7}, permutationKeyLength), big.NewInt(tt.domain))
+			seen := make(map[int64]int64, tt.domain)
+			for x := int64(0); x < tt.domain; x++ {
+				y := p.Apply(big.NewInt(x))
+				if y.Sign() < 0 || y.Int64() >= tt.domain {
+					t.Fatalf("apply(%d) = %s, outside [0, %d)", x, y, tt.domain)
+				}
+				if previous, ok := seen[y.Int64()]; ok {
+					t.Fatalf("apply(%d) = apply(%d) = %s", x, previous, y)
+				}
+				seen[y.Int64()] = x
+			}
+		})
+	}
+}
+
+// сеть Фейстеля сама по себе - биекция на 2^(2*halfBits) значениях
+func TestFeistelIsBijection(t *testing.T) {
+	for _, domain := range []int64{2, 100, 257, 4096} {
+		p := NewPermutation([]byte("feistel"), big.NewInt(domain))
+		size := int64(1) << (2 * p.halfBits)
+		seen := make(map[int64]bool, size)
+		for x := int64(0); x < size; x++ {
+			y := p.feistel(big.NewInt(x)).Int64()
+			if y < 0 || y >= size || seen[y] {
+				t.Fatalf("domain %d: feistel(%d) = %d is out of range or repeated", domain, x, y)
+			}
+			seen[y] = true
+		}
+	}
+}
+
+func TestKeyPermutationDependsOnKey(t *testing.T) {
+	domain := big.NewInt(1 << 20)
+	a := NewPermutation([]byte("key a"), domain)
+	b := NewPermutation([]byte("key b"), domain)
+	same := 0
+	for x := int64(0); x < 100; x++ {
+		if a.Apply(big.NewInt(x)).Cmp(b.Apply(big.NewInt(x))) == 0 {
+			same++
+		}
+	}
+	if same > 5 {
+		t.Errorf("%d of 100 numbers map to the same value under different keys", same)
+	}
+	// та же перестановка при том же ключе
+	if a.Apply(big.NewInt(42)).Cmp(NewPermutation([]byte("key a"), domain).Apply(big.NewInt(42))) != 0 {
+		t.Error("permutation is not deterministic")
+	}
+}
+
+func TestKeyAt(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		checksum string
+		index    int64
+		want     string
+	}{
+		// символы X идут в порядке A-Z, затем 0-9
+		{"XX", "", 0, "AA"},
+		{"XX", "", 35, "A9"},
+		{"XX", "", 36, "BA"},
+		{"XX", "", 36*36 - 1, "99"},
+		{"AB-XX", "", 37, "AB-BB"},
+		{"XX", checksumLuhn, 0, "AA6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			p, err := New(tt.pattern, tt.checksum, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.KeyAt(big.NewInt(tt.index)); got != tt.want {
+				t.Errorf("KeyAt(%d) = %q, want %q", tt.index, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package keygen
+
+import (
+	"crypto/rand"
+	"io"
+	"math"
+)
+
+// Source выдаёт равномерно распределённые индексы из криптостойкого потока.
+// Байты читаются пачками, чтобы не ходить в crypto/rand за каждым символом.
+type Source struct {
+	reader io.Reader
+	buf    [64]byte
+	pos    int
+}
+
+// NewSource читает случайные байты из reader
+func NewSource(reader io.Reader) *Source {
+	s := &Source{reader: reader}
+	s.pos = len(s.buf)
+	return s
+}
+
+func (s *Source) nextByte() byte {
+	if s.pos == len(s.buf) {
+		// crypto/rand не возвращает ошибок в поддерживаемых версиях Go,
+		// а без случайности выпускать ключи нельзя
+		if _, err := io.ReadFull(s.reader, s.buf[:]); err != nil {
+			panic("random source failed: " + err.Error())
+		}
+		s.pos = 0
+	}
+	b := s.buf[s.pos]
+	s.pos++
+	return b
+}
+
+// intn возвращает число в [0, n) для n <= 256. Байты из «хвоста», который не
+// делится на n нацело, отбрасываются, поэтому смещения по модулю нет.
+func (s *Source) intn(n int) int {
+	limit := 256 - 256%n
+	for {
+		b := int(s.nextByte())
+		if b < limit {
+			return b % n
+		}
+	}
+}
+
+// NewCryptoSource - источник на crypto/rand для обычной генерации
+func NewCryptoSource() *Source {
+	return NewSource(rand.Reader)
+}
+
+// EntropyBits считает энтропию случайной части ключа в битах
+func (p *Pattern) EntropyBits() float64 {
+	if p.Kind != "" {
+		return float64(p.kindInfo().RandomBits)
+	}
+	bits := 0.0
+	for _, token := range p.Tokens {
+		if token.IsPlaceholder() {
+			bits += math.Log2(float64(len(token.Charset)))
+		}
+	}
+	return bits
+}
+
+// Draw выпускает случайный ключ по шаблону вместе с контрольным символом
+func (p *Pattern) Draw(source *Source) string {
+	key := make([]byte, len(p.Tokens), p.KeyLength())
+
+	for i, token := range p.Tokens {
+		if token.IsPlaceholder() {
+			key[i] = token.Charset[source.intn(len(token.Charset))]
+		} else {
+			key[i] = token.Literal
+		}
+	}
+	if p.Checksum != checksumNone {
+		key = append(key, computeChecksum(p.Checksum, string(key)))
+	}
+	return string(key)
+}
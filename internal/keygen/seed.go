@@ -0,0 +1,53 @@
+package keygen
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+)
+
+// Детерминированная генерация для тестовых фикстур: одна и та же группа, seed и
+// количество всегда дают одни и те же ключи. Поток случайности - AES-256-CTR с ключом
+// из имени группы и seed.
+
+// CandidateAttemptsFactor - сколько кандидатов на один нужный ключ можно перебрать,
+// прежде чем считать пространство ключей исчерпанным
+const CandidateAttemptsFactor = 10
+
+var ErrKeyspaceExhausted = errors.New("group keyspace is exhausted")
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// newSeededSource - детерминированный криптостойкий поток для группы и seed
+func newSeededSource(groupName, seed string) *Source {
+	key := sha256.Sum256([]byte("seed\x00" + groupName + "\x00" + seed))
+	block, _ := aes.NewCipher(key[:])
+	stream := cipher.NewCTR(block, make([]byte, aes.BlockSize))
+	return NewSource(cipher.StreamReader{S: stream, R: zeroReader{}})
+}
+
+// Seeded выпускает count разных ключей группы groupName из seed. Стоп-лист и
+// подпись сюда не входят: их применяет вызывающий.
+func Seeded(groupName string, pattern *Pattern, seed string, count int) ([]string, error) {
+	source := newSeededSource(groupName, seed)
+	keys := make([]string, 0, count)
+	seen := make(map[string]struct{}, count)
+	for attempts := 0; len(keys) < count; attempts++ {
+		if attempts >= count*CandidateAttemptsFactor {
+			return nil, ErrKeyspaceExhausted
+		}
+		key := pattern.Draw(source)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
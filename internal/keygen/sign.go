@@ -0,0 +1,37 @@
+package keygen
+
+import (
+	"fmt"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/keysign"
+)
+
+// ActiveSigningKey возвращает ключ, которым подписываются новые ключи группы
+func ActiveSigningKey(ring *keysign.Keyring, groupName, algorithm string) (*keysign.Key, error) {
+	k, err := ring.Active(groupName)
+	if err != nil {
+		return nil, err
+	}
+	if k.Algorithm != algorithm {
+		return nil, fmt.Errorf("%w %s with algorithm %s", keysign.ErrNoSigningKey, groupName, algorithm)
+	}
+	return k, nil
+}
+
+// Sign подписывает ключи активным ключом группы; без algorithm ключи возвращаются как есть
+func Sign(ring *keysign.Keyring, groupName, algorithm string, keys []string) ([]string, error) {
+	if algorithm == "" {
+		return keys, nil
+	}
+	k, err := ActiveSigningKey(ring, groupName, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	signed := make([]string, len(keys))
+	for i, key := range keys {
+		if signed[i], err = k.Sign(key); err != nil {
+			return nil, err
+		}
+	}
+	return signed, nil
+}
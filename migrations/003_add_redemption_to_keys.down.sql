@@ -0,0 +1,2 @@
+ALTER TABLE keys DROP COLUMN IF EXISTS redeemed_by;
+ALTER TABLE keys DROP COLUMN IF EXISTS redeemed_at;
@@ -0,0 +1,2 @@
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS redeemed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS redeemed_by VARCHAR(255);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS groups (
+    name VARCHAR(100) PRIMARY KEY,
+    pattern VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO groups (name, pattern) VALUES
+    ('promo', 'AVITO-XXXX-XXXX'),
+    ('discount', 'AVITO-DISC-XXX'),
+    ('user_token', 'AVITO-USER-XXXXXX'),
+    ('api_key', 'AVITO-API-XXXXXXXX'),
+    ('partner', 'AVITO-PART-XXXX')
+ON CONFLICT (name) DO NOTHING;
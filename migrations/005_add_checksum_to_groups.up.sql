@@ -0,0 +1 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS checksum VARCHAR(20) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS min_entropy_bits INTEGER NOT NULL DEFAULT 0;
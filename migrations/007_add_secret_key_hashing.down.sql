@@ -0,0 +1,8 @@
+ALTER TABLE keys DROP CONSTRAINT IF EXISTS key_value_or_hash;
+ALTER TABLE keys DROP CONSTRAINT IF EXISTS unique_key_hash;
+ALTER TABLE keys DROP COLUMN IF EXISTS key_prefix;
+ALTER TABLE keys DROP COLUMN IF EXISTS key_hash;
+DELETE FROM keys WHERE key_value IS NULL;
+ALTER TABLE keys ALTER COLUMN key_value SET NOT NULL;
+
+ALTER TABLE groups DROP COLUMN IF EXISTS secret;
@@ -0,0 +1,7 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS secret BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE keys ALTER COLUMN key_value DROP NOT NULL;
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS key_hash VARCHAR(64);
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS key_prefix VARCHAR(255);
+ALTER TABLE keys ADD CONSTRAINT unique_key_hash UNIQUE (key_hash);
+ALTER TABLE keys ADD CONSTRAINT key_value_or_hash CHECK (key_value IS NOT NULL OR key_hash IS NOT NULL);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_keys_job;
+ALTER TABLE keys DROP COLUMN IF EXISTS job_id;
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    group_name VARCHAR(100) NOT NULL,
+    requested_count INTEGER NOT NULL,
+    generated_count INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    error TEXT,
+    heartbeat_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_jobs_status ON jobs(status);
+
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS job_id BIGINT REFERENCES jobs(id);
+CREATE INDEX idx_keys_job ON keys(job_id, id);
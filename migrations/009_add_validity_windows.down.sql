@@ -0,0 +1,11 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS valid_from;
+
+DROP INDEX IF EXISTS idx_keys_expires;
+ALTER TABLE keys DROP COLUMN IF EXISTS expired;
+ALTER TABLE keys DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE keys DROP COLUMN IF EXISTS valid_from;
+
+ALTER TABLE groups DROP COLUMN IF EXISTS key_ttl_seconds;
+ALTER TABLE groups DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE groups DROP COLUMN IF EXISTS valid_from;
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS key_events;
+
+DROP INDEX IF EXISTS idx_keys_expires;
+
+ALTER TABLE keys DROP COLUMN IF EXISTS revoke_reason;
+ALTER TABLE keys DROP COLUMN IF EXISTS revoked_by;
+ALTER TABLE keys DROP COLUMN IF EXISTS revoked_at;
+
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS expired BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE keys SET expired = TRUE WHERE status = 'expired';
+
+ALTER TABLE keys ALTER COLUMN status DROP DEFAULT;
+ALTER TABLE keys ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE keys ALTER COLUMN status TYPE BOOLEAN USING (status = 'active');
+ALTER TABLE keys ALTER COLUMN status SET DEFAULT TRUE;
+
+CREATE INDEX idx_keys_expires ON keys(expires_at) WHERE status = TRUE;
+
+DROP TYPE IF EXISTS key_status;
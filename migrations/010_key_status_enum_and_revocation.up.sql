@@ -0,0 +1,33 @@
+CREATE TYPE key_status AS ENUM ('active', 'redeemed', 'revoked', 'expired');
+
+DROP INDEX IF EXISTS idx_keys_expires;
+
+ALTER TABLE keys ALTER COLUMN status DROP DEFAULT;
+ALTER TABLE keys ALTER COLUMN status TYPE key_status USING (
+    CASE
+        WHEN expired THEN 'expired'
+        WHEN status THEN 'active'
+        ELSE 'redeemed'
+    END
+)::key_status;
+ALTER TABLE keys ALTER COLUMN status SET DEFAULT 'active';
+ALTER TABLE keys ALTER COLUMN status SET NOT NULL;
+ALTER TABLE keys DROP COLUMN IF EXISTS expired;
+
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS revoked_by VARCHAR(255);
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS revoke_reason TEXT;
+
+CREATE INDEX idx_keys_expires ON keys(expires_at) WHERE status = 'active';
+
+-- история отзывов и восстановлений ключей
+CREATE TABLE IF NOT EXISTS key_events (
+    id BIGSERIAL PRIMARY KEY,
+    key_id INTEGER NOT NULL REFERENCES keys(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_key_events_key ON key_events(key_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_keys_display_prefix;
+DROP INDEX IF EXISTS idx_keys_value_prefix;
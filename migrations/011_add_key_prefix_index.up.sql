@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_keys_value_prefix ON keys(key_value text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_keys_display_prefix ON keys(key_prefix text_pattern_ops);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS key_redemptions;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS max_uses_per_subject;
+ALTER TABLE jobs DROP COLUMN IF EXISTS max_uses;
+
+ALTER TABLE keys DROP COLUMN IF EXISTS use_count;
+ALTER TABLE keys DROP COLUMN IF EXISTS max_uses_per_subject;
+ALTER TABLE keys DROP COLUMN IF EXISTS max_uses;
+
+ALTER TABLE groups DROP COLUMN IF EXISTS max_uses_per_subject;
+ALTER TABLE groups DROP COLUMN IF EXISTS max_uses;
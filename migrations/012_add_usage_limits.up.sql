@@ -0,0 +1,28 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS max_uses INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS max_uses_per_subject INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS max_uses INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS max_uses_per_subject INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS use_count INTEGER NOT NULL DEFAULT 0;
+UPDATE keys SET use_count = 1 WHERE status = 'redeemed';
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS max_uses INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS max_uses_per_subject INTEGER NOT NULL DEFAULT 0;
+
+-- журнал погашений: одна строка на каждое использование ключа
+CREATE TABLE IF NOT EXISTS key_redemptions (
+    id BIGSERIAL PRIMARY KEY,
+    key_id INTEGER NOT NULL REFERENCES keys(id) ON DELETE CASCADE,
+    subject_id VARCHAR(255) NOT NULL,
+    redeemed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    request_id VARCHAR(255),
+    client_ip VARCHAR(64),
+    user_agent TEXT,
+    metadata JSONB
+);
+
+CREATE INDEX idx_key_redemptions_key_subject ON key_redemptions(key_id, subject_id);
+
+INSERT INTO key_redemptions (key_id, subject_id, redeemed_at)
+SELECT id, redeemed_by, redeemed_at FROM keys
+WHERE status = 'redeemed' AND redeemed_by IS NOT NULL;
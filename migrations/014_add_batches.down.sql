@@ -0,0 +1,6 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS batch_id;
+
+DROP INDEX IF EXISTS idx_keys_batch;
+ALTER TABLE keys DROP COLUMN IF EXISTS batch_id;
+
+DROP TABLE IF EXISTS batches;
@@ -0,0 +1,17 @@
+-- партия ключей: один вызов генерации
+CREATE TABLE IF NOT EXISTS batches (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    group_name VARCHAR(100) NOT NULL,
+    requested_count INTEGER NOT NULL,
+    created_by VARCHAR(255),
+    metadata JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_batches_group ON batches(group_name, id);
+
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS batch_id BIGINT REFERENCES batches(id);
+CREATE INDEX idx_keys_batch ON keys(batch_id, id);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS batch_id BIGINT REFERENCES batches(id);
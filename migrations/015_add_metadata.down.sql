@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_keys_metadata;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS key_metadata;
+ALTER TABLE keys DROP COLUMN IF EXISTS metadata;
+ALTER TABLE groups DROP COLUMN IF EXISTS metadata;
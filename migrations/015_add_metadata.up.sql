@@ -0,0 +1,6 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS metadata JSONB;
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS metadata JSONB;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS key_metadata JSONB;
+
+-- фильтр списка ключей по полям метаданных идёт через оператор @>
+CREATE INDEX idx_keys_metadata ON keys USING GIN (metadata jsonb_path_ops);
@@ -0,0 +1,2 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS alphabet VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS normalize BOOLEAN NOT NULL DEFAULT FALSE;
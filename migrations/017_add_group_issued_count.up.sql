@@ -0,0 +1,7 @@
+-- сколько ключей группы выпущено или зарезервировано незавершёнными задачами
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS issued_count BIGINT NOT NULL DEFAULT 0;
+
+UPDATE groups g SET issued_count =
+    (SELECT COUNT(*) FROM keys k WHERE k.group_name = g.name) +
+    (SELECT COALESCE(SUM(j.requested_count - j.generated_count), 0) FROM jobs j
+     WHERE j.group_name = g.name AND j.status IN ('pending', 'running'));
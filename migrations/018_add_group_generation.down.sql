@@ -0,0 +1,3 @@
+ALTER TABLE groups DROP COLUMN IF EXISTS key_counter;
+ALTER TABLE groups DROP COLUMN IF EXISTS permutation_key;
+ALTER TABLE groups DROP COLUMN IF EXISTS generation;
@@ -0,0 +1,4 @@
+-- random - случайные кандидаты, permuted - счётчик через ключевую перестановку
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS generation VARCHAR(20) NOT NULL DEFAULT 'random';
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS permutation_key BYTEA;
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS key_counter BIGINT NOT NULL DEFAULT 0;
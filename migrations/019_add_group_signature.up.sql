@@ -0,0 +1,2 @@
+-- алгоритм подписи ключей группы: пусто - без подписи, hmac или ed25519
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS signature VARCHAR(20) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- вид стандартного идентификатора (uuidv4, ulid, ...) вместо шаблона; пусто - ключи по шаблону
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- ключи идемпотентности принадлежат вызывающему: хеш его учётных данных
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS scope CHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, idempotency_key);
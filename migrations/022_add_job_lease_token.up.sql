@@ -0,0 +1,2 @@
+-- токен аренды задачи: писать в задачу может только воркер, взявший её последним
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lease_token CHAR(32);
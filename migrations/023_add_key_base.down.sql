@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS unique_group_key_base;
+ALTER TABLE keys DROP COLUMN IF EXISTS key_base;
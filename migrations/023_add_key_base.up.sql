@@ -0,0 +1,17 @@
+-- исходный ключ без подписи (у секретных групп - его HMAC): по нему уникальны ключи
+-- групп с подписью, иначе после ротации тот же исходный ключ выдавался бы повторно
+ALTER TABLE keys ADD COLUMN IF NOT EXISTS key_base VARCHAR(255);
+
+-- открытые подписанные ключи: база - всё до предпоследней точки; из уже выданных
+-- повторов базу получает только первый ключ. Секретные ключи без перца не заполнить
+UPDATE keys SET key_base = b.base
+FROM (
+    SELECT DISTINCT ON (k.group_name, regexp_replace(k.key_value, '\.[^.]*\.[^.]*$', ''))
+        k.id, regexp_replace(k.key_value, '\.[^.]*\.[^.]*$', '') AS base
+    FROM keys k JOIN groups g ON g.name = k.group_name
+    WHERE g.signature <> '' AND k.key_value IS NOT NULL
+    ORDER BY k.group_name, regexp_replace(k.key_value, '\.[^.]*\.[^.]*$', ''), k.id
+) b
+WHERE keys.id = b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS unique_group_key_base ON keys(group_name, key_base);
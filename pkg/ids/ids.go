@@ -0,0 +1,363 @@
+// Package ids выпускает и проверяет стандартные идентификаторы: UUIDv4, UUIDv7, ULID,
+// KSUID и 64-битный Snowflake. Генератор потокобезопасен; UUIDv7, ULID и Snowflake
+// одного генератора строго возрастают даже в пределах одной миллисекунды.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UUIDv4    = "uuidv4"
+	UUIDv7    = "uuidv7"
+	ULID      = "ulid"
+	KSUID     = "ksuid"
+	Snowflake = "snowflake"
+)
+
+const (
+	// биты Snowflake: 41 - миллисекунды от SnowflakeEpoch, 10 - узел, 12 - номер в миллисекунде
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	MaxSnowflakeNode      = 1<<snowflakeNodeBits - 1
+
+	// эпоха KSUID по спецификации
+	ksuidEpoch = 1400000000
+
+	// NoNode - узел не назначен: генератор не выпускает Snowflake
+	NoNode = -1
+)
+
+// SnowflakeEpoch - начало отсчёта времени Snowflake
+var SnowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	ErrUnknownKind = errors.New("unknown id kind")
+	ErrBadNode     = fmt.Errorf("snowflake node must be between 0 and %d", MaxSnowflakeNode)
+	ErrNoNode      = errors.New("snowflake node is not configured")
+)
+
+const (
+	crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	base62Alphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// Info описывает вид идентификатора
+type Info struct {
+	Kind string `json:"kind"`
+	// максимальная длина в символах
+	Length int `json:"length"`
+	// размер пространства значений и его случайной части в битах
+	Bits       int `json:"bits"`
+	RandomBits int `json:"random_bits"`
+}
+
+var kinds = map[string]Info{
+	UUIDv4:    {Kind: UUIDv4, Length: 36, Bits: 122, RandomBits: 122},
+	UUIDv7:    {Kind: UUIDv7, Length: 36, Bits: 122, RandomBits: 62},
+	ULID:      {Kind: ULID, Length: 26, Bits: 128, RandomBits: 80},
+	KSUID:     {Kind: KSUID, Length: 27, Bits: 160, RandomBits: 128},
+	Snowflake: {Kind: Snowflake, Length: 19, Bits: 63, RandomBits: 0},
+}
+
+// Lookup возвращает описание вида идентификатора
+func Lookup(kind string) (Info, bool) {
+	info, ok := kinds[kind]
+	return info, ok
+}
+
+// Kinds возвращает все виды идентификаторов по алфавиту
+func Kinds() []string {
+	names := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Generator struct {
+	random io.Reader
+	now    func() time.Time
+	node   int64
+
+	mu sync.Mutex
+	// последние выданные значения для монотонности
+	uuidv7Millis   int64
+	uuidv7Sequence uint16
+	ulidMillis     int64
+	ulidRandom     [10]byte
+	snowflakeLast  int64
+	snowflakeSeq   int64
+}
+
+// NewGenerator создаёт генератор; node различает экземпляры сервиса в Snowflake и должен
+// быть своим у каждого экземпляра - генератор этого проверить не может. С NoNode
+// генератор выпускает все виды, кроме Snowflake
+func NewGenerator(node int64) (*Generator, error) {
+	if node < NoNode || node > MaxSnowflakeNode {
+		return nil, ErrBadNode
+	}
+	return &Generator{random: rand.Reader, now: time.Now, node: node}, nil
+}
+
+// New выпускает один идентификатор вида kind
+func (g *Generator) New(kind string) (string, error) {
+	switch kind {
+	case UUIDv4:
+		return g.uuidv4(), nil
+	case UUIDv7:
+		return g.uuidv7(), nil
+	case ULID:
+		return g.ulid(), nil
+	case KSUID:
+		return g.ksuid(), nil
+	case Snowflake:
+		if !g.HasNode() {
+			return "", ErrNoNode
+		}
+		return strconv.FormatInt(g.snowflake(), 10), nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownKind, kind)
+}
+
+// HasNode сообщает, назначен ли генератору узел Snowflake
+func (g *Generator) HasNode() bool {
+	return g.node != NoNode
+}
+
+func (g *Generator) read(buf []byte) {
+	// без случайности выпускать идентификаторы нельзя
+	if _, err := io.ReadFull(g.random, buf); err != nil {
+		panic("random source failed: " + err.Error())
+	}
+}
+
+func (g *Generator) uuidv4() string {
+	var id [16]byte
+	g.read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id)
+}
+
+// uuidv7 использует 12 бит rand_a как счётчик внутри миллисекунды (RFC 9562, метод 1);
+// при переполнении счётчика время сдвигается на миллисекунду вперёд
+func (g *Generator) uuidv7() string {
+	var id [16]byte
+	g.read(id[6:])
+
+	g.mu.Lock()
+	millis := max(g.now().UnixMilli(), g.uuidv7Millis)
+	if millis == g.uuidv7Millis {
+		g.uuidv7Sequence++
+		if g.uuidv7Sequence > 0x0fff {
+			millis++
+			g.uuidv7Sequence = 0
+		}
+	} else {
+		// старт со случайного значения в нижней половине, чтобы было куда расти
+		g.uuidv7Sequence = uint16(id[6])<<3 | uint16(id[7])>>5
+	}
+	g.uuidv7Millis = millis
+	sequence := g.uuidv7Sequence
+	g.mu.Unlock()
+
+	putMillis(id[:6], millis)
+	id[6] = 0x70 | byte(sequence>>8)
+	id[7] = byte(sequence)
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id)
+}
+
+// ulid в пределах миллисекунды увеличивает случайную часть на единицу, как в
+// монотонном режиме спецификации ULID
+func (g *Generator) ulid() string {
+	var id [16]byte
+
+	g.mu.Lock()
+	millis := max(g.now().UnixMilli(), g.ulidMillis)
+	if millis != g.ulidMillis || !increment(g.ulidRandom[:]) {
+		// новая миллисекунда или переполнение случайной части
+		if millis == g.ulidMillis {
+			millis++
+		}
+		g.read(g.ulidRandom[:])
+	}
+	g.ulidMillis = millis
+	copy(id[6:], g.ulidRandom[:])
+	g.mu.Unlock()
+
+	putMillis(id[:6], millis)
+	return encodeCrockford(id)
+}
+
+func (g *Generator) ksuid() string {
+	var id [20]byte
+	seconds := uint32(g.now().Unix() - ksuidEpoch)
+	id[0], id[1], id[2], id[3] = byte(seconds>>24), byte(seconds>>16), byte(seconds>>8), byte(seconds)
+	g.read(id[4:])
+	return encodeBase62(id[:], kinds[KSUID].Length)
+}
+
+func (g *Generator) snowflake() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	millis := max(g.now().Sub(SnowflakeEpoch).Milliseconds(), g.snowflakeLast)
+	if millis == g.snowflakeLast {
+		g.snowflakeSeq++
+		if g.snowflakeSeq >= 1<<snowflakeSequenceBits {
+			millis++
+			g.snowflakeSeq = 0
+		}
+	} else {
+		g.snowflakeSeq = 0
+	}
+	g.snowflakeLast = millis
+	return millis<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.snowflakeSeq
+}
+
+// Canonical приводит регистр id к записи генератора: UUID - строчные, ULID - прописные
+func Canonical(kind, id string) string {
+	switch kind {
+	case UUIDv4, UUIDv7:
+		return strings.ToLower(id)
+	case ULID:
+		return strings.ToUpper(id)
+	}
+	return id
+}
+
+// Valid проверяет, что id - идентификатор вида kind; регистр UUID и ULID не важен
+func Valid(kind, id string) bool {
+	id = Canonical(kind, id)
+	switch kind {
+	case UUIDv4:
+		return validUUID(id, 4)
+	case UUIDv7:
+		return validUUID(id, 7)
+	case ULID:
+		// 26 символов - 130 бит, поэтому первый символ не больше 7
+		return len(id) == kinds[ULID].Length && id[0] <= '7' && onlyFrom(id, crockfordAlphabet)
+	case KSUID:
+		if len(id) != kinds[KSUID].Length || !onlyFrom(id, base62Alphabet) {
+			return false
+		}
+		return decodeBase62(id).BitLen() <= 160
+	case Snowflake:
+		if id == "" || id[0] == '0' || len(id) > kinds[Snowflake].Length {
+			return false
+		}
+		value, err := strconv.ParseInt(id, 10, 64)
+		return err == nil && value > 0
+	}
+	return false
+}
+
+func formatUUID(id [16]byte) string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf)
+}
+
+func validUUID(id string, version byte) bool {
+	if len(id) != 36 || id[8] != '-' || id[13] != '-' || id[18] != '-' || id[23] != '-' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			continue
+		}
+		if !(id[i] >= '0' && id[i] <= '9' || id[i] >= 'a' && id[i] <= 'f') {
+			return false
+		}
+	}
+	return id[14] == '0'+version && (id[19] == '8' || id[19] == '9' || id[19] == 'a' || id[19] == 'b')
+}
+
+func putMillis(buf []byte, millis int64) {
+	for i := 5; i >= 0; i-- {
+		buf[i] = byte(millis)
+		millis >>= 8
+	}
+}
+
+// increment прибавляет единицу к big-endian числу; false - переполнение
+func increment(buf []byte) bool {
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i]++
+		if buf[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeCrockford(id [16]byte) string {
+	value := new(big.Int).SetBytes(id[:])
+	out := make([]byte, kinds[ULID].Length)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockfordAlphabet[value.Uint64()&0x1f]
+		value.Rsh(value, 5)
+	}
+	return string(out)
+}
+
+func encodeBase62(data []byte, length int) string {
+	value := new(big.Int).SetBytes(data)
+	base := big.NewInt(int64(len(base62Alphabet)))
+	digit := new(big.Int)
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		value.DivMod(value, base, digit)
+		out[i] = base62Alphabet[digit.Int64()]
+	}
+	return string(out)
+}
+
+func decodeBase62(s string) *big.Int {
+	value := new(big.Int)
+	base := big.NewInt(int64(len(base62Alphabet)))
+	for i := 0; i < len(s); i++ {
+		value.Mul(value, base)
+		value.Add(value, big.NewInt(int64(indexOf(base62Alphabet, s[i]))))
+	}
+	return value
+}
+
+func onlyFrom(s, alphabet string) bool {
+	for i := 0; i < len(s); i++ {
+		if indexOf(alphabet, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func indexOf(alphabet string, char byte) int {
+	for i := 0; i < len(alphabet); i++ {
+		if alphabet[i] == char {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,267 @@
+package ids
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testGenerator - генератор с остановленными часами и предсказуемой случайностью
+func testGenerator(t *testing.T, node int64, millis int64) *Generator {
+	t.Helper()
+	g, err := NewGenerator(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.now = func() time.Time { return time.UnixMilli(millis) }
+	g.random = bytes.NewReader(bytes.Repeat([]byte{0x5a}, 1<<20))
+	return g
+}
+
+func uuidMillis(t *testing.T, id string) int64 {
+	t.Helper()
+	millis, err := strconv.ParseInt(strings.ReplaceAll(id[:13], "-", ""), 16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return millis
+}
+
+func ulidMillis(id string) int64 {
+	var millis int64
+	for i := 0; i < 10; i++ {
+		millis = millis<<5 | int64(strings.IndexByte(crockfordAlphabet, id[i]))
+	}
+	return millis
+}
+
+// в пределах одной миллисекунды идентификаторы строго возрастают, в том числе через
+// переполнение счётчика, которое сдвигает время вперёд
+func TestMonotonic(t *testing.T) {
+	const millis = 1700000000000
+	tests := []struct {
+		kind  string
+		count int
+	}{
+		// 12-битный счётчик UUIDv7 переполняется за 4096 идентификаторов
+		{UUIDv7, 5000},
+		{ULID, 5000},
+		{Snowflake, 5000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			g := testGenerator(t, 3, millis)
+			previous := ""
+			for i := 0; i < tt.count; i++ {
+				id, err := g.New(tt.kind)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !Valid(tt.kind, id) {
+					t.Fatalf("%q is not a valid %s", id, tt.kind)
+				}
+				if previous != "" && !less(tt.kind, previous, id) {
+					t.Fatalf("%q is not after %q", id, previous)
+				}
+				previous = id
+			}
+		})
+	}
+}
+
+func less(kind, a, b string) bool {
+	if kind == Snowflake {
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return x < y
+	}
+	return a < b
+}
+
+func TestUUIDv7SequenceOverflow(t *testing.T) {
+	const millis = 1700000000000
+	g := testGenerator(t, NoNode, millis)
+	g.uuidv7Millis, g.uuidv7Sequence = millis, 0x0ffe
+
+	last := g.uuidv7()
+	if got := uuidMillis(t, last); got != millis {
+		t.Errorf("last id of the millisecond has time %d, want %d", got, millis)
+	}
+	if last[15:18] != "fff" {
+		t.Errorf("last id of the millisecond %q has sequence %s, want fff", last, last[15:18])
+	}
+	next := g.uuidv7()
+	if got := uuidMillis(t, next); got != millis+1 {
+		t.Errorf("id after overflow has time %d, want %d", got, millis+1)
+	}
+	if next[15:18] != "000" || next <= last {
+		t.Errorf("id after overflow %q, previous %q", next, last)
+	}
+}
+
+func TestULIDRandomOverflow(t *testing.T) {
+	const millis = 1700000000000
+	tests := []struct {
+		name       string
+		random     [10]byte
+		wantMillis int64
+	}{
+		{"increment", [10]byte{9: 0xfe}, millis},
+		{"carry", [10]byte{8: 0x01, 9: 0xff}, millis},
+		{"overflow", [10]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, millis + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := testGenerator(t, NoNode, millis)
+			g.ulidMillis, g.ulidRandom = millis, tt.random
+			// идентификатор, выданный последним
+			var last [16]byte
+			putMillis(last[:6], millis)
+			copy(last[6:], tt.random[:])
+			previous := encodeCrockford(last)
+
+			id := g.ulid()
+			if got := ulidMillis(id); got != tt.wantMillis {
+				t.Errorf("ulid time %d, want %d", got, tt.wantMillis)
+			}
+			if id <= previous {
+				t.Errorf("%q is not after %q", id, previous)
+			}
+			if tt.wantMillis == millis {
+				want := tt.random
+				increment(want[:])
+				if g.ulidRandom != want {
+					t.Errorf("random part %x, want %x", g.ulidRandom, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSnowflakeSequenceOverflow(t *testing.T) {
+	const node = 1023
+	start := SnowflakeEpoch.Add(time.Hour)
+	g := testGenerator(t, node, start.UnixMilli())
+	millis := time.Hour.Milliseconds()
+	g.snowflakeLast, g.snowflakeSeq = millis, 1<<snowflakeSequenceBits-2
+
+	tests := []struct {
+		wantMillis   int64
+		wantSequence int64
+	}{
+		{millis, 1<<snowflakeSequenceBits - 1},
+		{millis + 1, 0},
+		{millis + 1, 1},
+	}
+	for _, tt := range tests {
+		id := g.snowflake()
+		if got := id >> (snowflakeNodeBits + snowflakeSequenceBits); got != tt.wantMillis {
+			t.Errorf("snowflake time %d, want %d", got, tt.wantMillis)
+		}
+		if got := id >> snowflakeSequenceBits & MaxSnowflakeNode; got != node {
+			t.Errorf("snowflake node %d, want %d", got, node)
+		}
+		if got := id & (1<<snowflakeSequenceBits - 1); got != tt.wantSequence {
+			t.Errorf("snowflake sequence %d, want %d", got, tt.wantSequence)
+		}
+	}
+}
+
+// часы, ушедшие назад, не ломают монотонность
+func TestClockGoesBack(t *testing.T) {
+	g := testGenerator(t, 1, 1700000000000)
+	before := map[string]string{}
+	for _, kind := range []string{UUIDv7, ULID, Snowflake} {
+		before[kind], _ = g.New(kind)
+	}
+	g.now = func() time.Time { return time.UnixMilli(1600000000000) }
+	for _, kind := range []string{UUIDv7, ULID, Snowflake} {
+		after, _ := g.New(kind)
+		if !less(kind, before[kind], after) {
+			t.Errorf("%s: %q is not after %q", kind, after, before[kind])
+		}
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	tests := []struct {
+		in, want []byte
+		ok       bool
+	}{
+		{[]byte{0, 0}, []byte{0, 1}, true},
+		{[]byte{0, 0xff}, []byte{1, 0}, true},
+		{[]byte{0xfe, 0xff}, []byte{0xff, 0}, true},
+		{[]byte{0xff, 0xff}, []byte{0, 0}, false},
+	}
+	for _, tt := range tests {
+		buf := append([]byte(nil), tt.in...)
+		if ok := increment(buf); ok != tt.ok || !bytes.Equal(buf, tt.want) {
+			t.Errorf("increment(%x) = %x, %v; want %x, %v", tt.in, buf, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNodes(t *testing.T) {
+	for _, node := range []int64{-2, MaxSnowflakeNode + 1} {
+		if _, err := NewGenerator(node); !errors.Is(err, ErrBadNode) {
+			t.Errorf("NewGenerator(%d) = %v, want ErrBadNode", node, err)
+		}
+	}
+	g, err := NewGenerator(NoNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.New(Snowflake); !errors.Is(err, ErrNoNode) {
+		t.Errorf("New(snowflake) without node = %v, want ErrNoNode", err)
+	}
+	if _, err := g.New(UUIDv7); err != nil {
+		t.Errorf("New(uuidv7) without node: %v", err)
+	}
+	if _, err := g.New("uuidv1"); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("New(uuidv1) = %v, want ErrUnknownKind", err)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		kind string
+		id   string
+		want bool
+	}{
+		{UUIDv4, "8f14e45f-ceea-4e7a-9c3b-0f2e5a1b6c7d", true},
+		{UUIDv4, "8F14E45F-CEEA-4E7A-9C3B-0F2E5A1B6C7D", true},
+		{UUIDv4, "8f14e45f-ceea-7e7a-9c3b-0f2e5a1b6c7d", false},
+		{UUIDv4, "8f14e45f-ceea-4e7a-cc3b-0f2e5a1b6c7d", false},
+		{UUIDv4, "8f14e45fceea4e7a9c3b0f2e5a1b6c7d", false},
+		{UUIDv4, "8f14e45f-ceea-4e7a-9c3b-0f2e5a1b6c7g", false},
+		{UUIDv7, "018bcfe5-6800-7abc-8def-0123456789ab", true},
+		{UUIDv7, "018BCFE5-6800-7ABC-BDEF-0123456789AB", true},
+		{UUIDv7, "018bcfe5-6800-4abc-8def-0123456789ab", false},
+		{ULID, "01HF7YAT00ABCDEFGHJKMNPQRS", true},
+		{ULID, "01hf7yat00abcdefghjkmnpqrs", true},
+		{ULID, "81HF7YAT00ABCDEFGHJKMNPQRS", false},
+		{ULID, "01HF7YAT00ABCDEFGHJKMNPQRU", false},
+		{ULID, "01HF7YAT00ABCDEFGHJKMNPQR", false},
+		{KSUID, "2Xh5Q0fP8bJv3dGZyTqW1mKcL9a", true},
+		// 2^160-1 - наибольший KSUID
+		{KSUID, "aWgEPTl1tmebfsQzFP4bxwgy80V", true},
+		{KSUID, "aWgEPTl1tmebfsQzFP4bxwgy80W", false},
+		{KSUID, "2Xh5Q0fP8bJv3dGZyTqW1mKcL9", false},
+		{Snowflake, "7000000000000000000", true},
+		{Snowflake, "0", false},
+		{Snowflake, "0123", false},
+		{Snowflake, "-1", false},
+		{Snowflake, "9999999999999999999", false},
+		{"uuidv1", "8f14e45f-ceea-1e7a-9c3b-0f2e5a1b6c7d", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind+"/"+tt.id, func(t *testing.T) {
+			if got := Valid(tt.kind, tt.id); got != tt.want {
+				t.Errorf("Valid(%q, %q) = %v, want %v", tt.kind, tt.id, got, tt.want)
+			}
+		})
+	}
+}
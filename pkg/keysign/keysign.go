@@ -0,0 +1,260 @@
+// Package keysign подписывает ключи групп и проверяет подпись без обращения к сервису.
+//
+// Подписанный ключ имеет вид <ключ>.<id ключа подписи>.<подпись>, где подпись -
+// HMAC-SHA256, обрезанный до HMACSize байт, или полная подпись Ed25519 над именем
+// группы, id ключа подписи и ключом по шаблону. Подпись кодируется в base32 Crockford.
+//
+// Партнёры проверяют ключи по тому же файлу ключей подписи, что и сервис: для Ed25519
+// им достаточно открытых ключей, для HMAC нужен общий секрет. Старые ключи подписи
+// остаются в файле после ротации, поэтому выпущенные ими ключи продолжают проверяться.
+package keysign
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	AlgorithmHMAC    = "hmac"
+	AlgorithmEd25519 = "ed25519"
+
+	// разделитель частей подписанного ключа
+	Separator = "."
+	// длина обрезанного HMAC в байтах
+	HMACSize = 10
+	// id ключа подписи - от 1 до MaxKeyIDLength символов A-Z и 0-9
+	MaxKeyIDLength = 8
+	// минимальная длина секрета HMAC в байтах
+	MinHMACSecretSize = 16
+)
+
+var encoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+var (
+	ErrMalformed     = errors.New("key is not signed or malformed")
+	ErrUnknownKeyID  = errors.New("unknown signing key id")
+	ErrBadSignature  = errors.New("signature does not match")
+	ErrNoSigningKey  = errors.New("no signing key for group")
+	ErrBadSigningKey = errors.New("invalid signing key")
+)
+
+// SignatureLength возвращает длину закодированной подписи алгоритма или 0
+func SignatureLength(algorithm string) int {
+	switch algorithm {
+	case AlgorithmHMAC:
+		return encoding.EncodedLen(HMACSize)
+	case AlgorithmEd25519:
+		return encoding.EncodedLen(ed25519.SignatureSize)
+	}
+	return 0
+}
+
+// Overhead - сколько символов подпись добавляет к ключу в худшем случае
+func Overhead(algorithm string) int {
+	return 2*len(Separator) + MaxKeyIDLength + SignatureLength(algorithm)
+}
+
+// Key - ключ подписи группы. Для проверки HMAC нужен Secret, для Ed25519 - PublicKey;
+// подписывать может только ключ с Secret или PrivateKey (seed Ed25519).
+type Key struct {
+	ID         string `json:"id"`
+	Group      string `json:"group"`
+	Algorithm  string `json:"algorithm"`
+	Secret     []byte `json:"secret,omitempty"`
+	PrivateKey []byte `json:"private_key,omitempty"`
+	PublicKey  []byte `json:"public_key,omitempty"`
+}
+
+func (k *Key) validate() error {
+	if k.ID == "" || len(k.ID) > MaxKeyIDLength {
+		return fmt.Errorf("%w: id must have 1 to %d characters", ErrBadSigningKey, MaxKeyIDLength)
+	}
+	for i := 0; i < len(k.ID); i++ {
+		if !(k.ID[i] >= 'A' && k.ID[i] <= 'Z' || k.ID[i] >= '0' && k.ID[i] <= '9') {
+			return fmt.Errorf("%w: id %q must contain only A-Z and 0-9", ErrBadSigningKey, k.ID)
+		}
+	}
+	if k.Group == "" {
+		return fmt.Errorf("%w: key %s has no group", ErrBadSigningKey, k.ID)
+	}
+
+	switch k.Algorithm {
+	case AlgorithmHMAC:
+		if len(k.Secret) < MinHMACSecretSize {
+			return fmt.Errorf("%w: key %s needs a secret of at least %d bytes", ErrBadSigningKey, k.ID, MinHMACSecretSize)
+		}
+	case AlgorithmEd25519:
+		if k.PrivateKey != nil {
+			if len(k.PrivateKey) != ed25519.SeedSize {
+				return fmt.Errorf("%w: key %s private_key must be a %d-byte seed", ErrBadSigningKey, k.ID, ed25519.SeedSize)
+			}
+			public := ed25519.NewKeyFromSeed(k.PrivateKey).Public().(ed25519.PublicKey)
+			if k.PublicKey != nil && !public.Equal(ed25519.PublicKey(k.PublicKey)) {
+				return fmt.Errorf("%w: key %s public_key does not match private_key", ErrBadSigningKey, k.ID)
+			}
+			k.PublicKey = public
+		}
+		if len(k.PublicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("%w: key %s needs a %d-byte public_key", ErrBadSigningKey, k.ID, ed25519.PublicKeySize)
+		}
+	default:
+		return fmt.Errorf("%w: key %s algorithm must be %s or %s", ErrBadSigningKey, k.ID, AlgorithmHMAC, AlgorithmEd25519)
+	}
+	return nil
+}
+
+// CanSign сообщает, есть ли у ключа секретная часть
+func (k *Key) CanSign() bool {
+	return k.Algorithm == AlgorithmHMAC || k.PrivateKey != nil
+}
+
+// Public возвращает копию ключа без секретов, которую можно отдать партнёрам
+func (k *Key) Public() Key {
+	public := Key{ID: k.ID, Group: k.Group, Algorithm: k.Algorithm}
+	if k.Algorithm == AlgorithmEd25519 {
+		public.PublicKey = k.PublicKey
+	}
+	return public
+}
+
+func (k *Key) message(base string) []byte {
+	return []byte(k.Group + "\x00" + k.ID + "\x00" + base)
+}
+
+func (k *Key) signature(base string) []byte {
+	if k.Algorithm == AlgorithmEd25519 {
+		return ed25519.Sign(ed25519.NewKeyFromSeed(k.PrivateKey), k.message(base))
+	}
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write(k.message(base))
+	return mac.Sum(nil)[:HMACSize]
+}
+
+// Sign дописывает к ключу id ключа подписи и подпись
+func (k *Key) Sign(base string) (string, error) {
+	if !k.CanSign() {
+		return "", fmt.Errorf("%w: key %s has no private part", ErrBadSigningKey, k.ID)
+	}
+	return base + Separator + k.ID + Separator + encoding.EncodeToString(k.signature(base)), nil
+}
+
+func (k *Key) verify(base string, signature []byte) bool {
+	if k.Algorithm == AlgorithmEd25519 {
+		return ed25519.Verify(ed25519.PublicKey(k.PublicKey), k.message(base), signature)
+	}
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write(k.message(base))
+	return hmac.Equal(mac.Sum(nil)[:HMACSize], signature)
+}
+
+// Split разбирает подписанный ключ на ключ по шаблону, id ключа подписи и подпись.
+// Части ищутся с конца, поэтому сам ключ может содержать разделитель.
+func Split(key string) (base, keyID, signature string, err error) {
+	rest, signature, ok := cutLast(key)
+	if !ok {
+		return "", "", "", ErrMalformed
+	}
+	base, keyID, ok = cutLast(rest)
+	if !ok || base == "" || keyID == "" || signature == "" {
+		return "", "", "", ErrMalformed
+	}
+	return base, keyID, signature, nil
+}
+
+func cutLast(s string) (before, after string, found bool) {
+	i := strings.LastIndex(s, Separator)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(Separator):], true
+}
+
+// Keyring - набор ключей подписи всех групп. Новые ключи группы подписывает
+// последний из её ключей с секретной частью, остальные только проверяют.
+type Keyring struct {
+	keys   map[string]*Key
+	order  []*Key
+	active map[string]*Key
+}
+
+func NewKeyring(keys []Key) (*Keyring, error) {
+	r := &Keyring{keys: map[string]*Key{}, active: map[string]*Key{}}
+	for i := range keys {
+		k := keys[i]
+		if err := k.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := r.keys[k.ID]; ok {
+			return nil, fmt.Errorf("%w: id %s repeats", ErrBadSigningKey, k.ID)
+		}
+		r.keys[k.ID] = &k
+		r.order = append(r.order, &k)
+		if k.CanSign() {
+			r.active[k.Group] = &k
+		}
+	}
+	return r, nil
+}
+
+// LoadKeyring читает файл вида {"keys": [{"id", "group", "algorithm", ...}]},
+// байтовые поля записываются в base64
+func LoadKeyring(path string) (*Keyring, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Keys []Key `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSigningKey, err)
+	}
+	return NewKeyring(file.Keys)
+}
+
+// Active возвращает ключ, которым подписываются новые ключи группы
+func (r *Keyring) Active(group string) (*Key, error) {
+	k, ok := r.active[group]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrNoSigningKey, group)
+	}
+	return k, nil
+}
+
+// Keys возвращает ключи в порядке файла без секретов
+func (r *Keyring) Keys() []Key {
+	keys := make([]Key, 0, len(r.order))
+	for _, k := range r.order {
+		keys = append(keys, k.Public())
+	}
+	return keys
+}
+
+// Verify проверяет подпись ключа и возвращает ключ по шаблону и ключ подписи,
+// по которому можно узнать группу
+func (r *Keyring) Verify(key string) (string, *Key, error) {
+	base, keyID, encoded, err := Split(key)
+	if err != nil {
+		return "", nil, err
+	}
+	k, ok := r.keys[keyID]
+	if !ok {
+		return "", nil, ErrUnknownKeyID
+	}
+	signature, err := encoding.DecodeString(encoded)
+	// у подписи ровно одна запись, иначе один ключ можно было бы предъявить в разных видах
+	if err != nil || len(encoded) != SignatureLength(k.Algorithm) || encoding.EncodeToString(signature) != encoded {
+		return "", nil, ErrMalformed
+	}
+	if !k.verify(base, signature) {
+		return "", nil, ErrBadSignature
+	}
+	return base, k, nil
+}
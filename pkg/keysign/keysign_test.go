@@ -0,0 +1,197 @@
+package keysign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	hmacSecret = bytes.Repeat([]byte{1}, MinHMACSecretSize)
+	edSeed     = bytes.Repeat([]byte{2}, ed25519.SeedSize)
+)
+
+func testKeyring(t *testing.T, keys ...Key) *Keyring {
+	t.Helper()
+	ring, err := NewKeyring(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func TestSignVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		key  Key
+		base string
+	}{
+		{"hmac", Key{ID: "H1", Group: "partner", Algorithm: AlgorithmHMAC, Secret: hmacSecret}, "PARTNER-ABCD-1234"},
+		{"ed25519", Key{ID: "E1", Group: "api_key", Algorithm: AlgorithmEd25519, PrivateKey: edSeed}, "AVITO-API-XXXX0000"},
+		// части ищутся с конца, поэтому точка в самом ключе не мешает
+		{"separator in base", Key{ID: "H1", Group: "partner", Algorithm: AlgorithmHMAC, Secret: hmacSecret}, "A.B.C"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := testKeyring(t, tt.key)
+			active, err := ring.Active(tt.key.Group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := active.Sign(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(tt.base) + 2*len(Separator) + len(tt.key.ID) + SignatureLength(tt.key.Algorithm); len(signed) != want {
+				t.Errorf("len(%q) = %d, want %d", signed, len(signed), want)
+			}
+			if len(signed)-len(tt.base) > Overhead(tt.key.Algorithm) {
+				t.Errorf("signature adds %d characters, more than Overhead", len(signed)-len(tt.base))
+			}
+			base, k, err := ring.Verify(signed)
+			if err != nil {
+				t.Fatalf("Verify(%q): %v", signed, err)
+			}
+			if base != tt.base || k.ID != tt.key.ID || k.Group != tt.key.Group {
+				t.Errorf("Verify(%q) = %q, %s/%s", signed, base, k.Group, k.ID)
+			}
+			// подпись детерминирована
+			if again, _ := active.Sign(tt.base); again != signed {
+				t.Errorf("Sign is not deterministic: %q != %q", again, signed)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old := Key{ID: "K1", Group: "partner", Algorithm: AlgorithmHMAC, Secret: hmacSecret}
+	signedOld, err := testKeyring(t, old).keys["K1"].Sign("PARTNER-0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// новый ключ дописан в конец файла
+	current := Key{ID: "K2", Group: "partner", Algorithm: AlgorithmHMAC, Secret: bytes.Repeat([]byte{3}, MinHMACSecretSize)}
+	ring := testKeyring(t, old, current)
+	active, err := ring.Active("partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active.ID != "K2" {
+		t.Errorf("active key is %s, want K2", active.ID)
+	}
+	if _, _, err := ring.Verify(signedOld); err != nil {
+		t.Errorf("key signed before rotation: %v", err)
+	}
+	signedNew, _ := active.Sign("PARTNER-0001")
+	if signedNew == signedOld {
+		t.Error("the same base is signed identically by different keys")
+	}
+	if base, k, err := ring.Verify(signedNew); err != nil || base != "PARTNER-0001" || k.ID != "K2" {
+		t.Errorf("Verify(%q) = %q, %v, %v", signedNew, base, k, err)
+	}
+
+	// ключ только с открытой частью проверяет, но не подписывает
+	public := Key{ID: "K3", Group: "partner", Algorithm: AlgorithmEd25519,
+		PublicKey: ed25519.NewKeyFromSeed(edSeed).Public().(ed25519.PublicKey)}
+	ring = testKeyring(t, old, current, public)
+	if active, _ := ring.Active("partner"); active.ID != "K2" {
+		t.Errorf("active key is %s, want K2", active.ID)
+	}
+	// после удаления старого ключа из файла его ключи не проверяются
+	if _, _, err := testKeyring(t, current).Verify(signedOld); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Verify after removing K1: %v, want ErrUnknownKeyID", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	hmacKey := Key{ID: "H1", Group: "partner", Algorithm: AlgorithmHMAC, Secret: hmacSecret}
+	edKey := Key{ID: "E1", Group: "api_key", Algorithm: AlgorithmEd25519, PrivateKey: edSeed}
+	ring := testKeyring(t, hmacKey, edKey)
+	signed, _ := ring.keys["H1"].Sign("PARTNER-0001")
+	signedEd, _ := ring.keys["E1"].Sign("API-0001")
+	base, _, signature, _ := Split(signed)
+
+	// другая подпись той же длины
+	flipped := []byte(signature)
+	if flipped[0] == '0' {
+		flipped[0] = '1'
+	} else {
+		flipped[0] = '0'
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		want error
+	}{
+		{"empty", "", ErrMalformed},
+		{"unsigned", "PARTNER-0001", ErrMalformed},
+		{"no key id", "PARTNER-0001." + signature, ErrMalformed},
+		{"empty base", ".H1." + signature, ErrMalformed},
+		{"empty key id", base + ".." + signature, ErrMalformed},
+		{"empty signature", base + ".H1.", ErrMalformed},
+		{"unknown key id", base + ".H9." + signature, ErrUnknownKeyID},
+		{"lowercase signature", base + ".H1." + strings.ToLower(signature), ErrMalformed},
+		{"short signature", base + ".H1." + signature[1:], ErrMalformed},
+		{"long signature", base + ".H1." + signature + "0", ErrMalformed},
+		{"not base32", base + ".H1." + strings.Repeat("U", len(signature)), ErrMalformed},
+		{"tampered base", "PARTNER-0002.H1." + signature, ErrBadSignature},
+		{"tampered signature", base + ".H1." + string(flipped), ErrBadSignature},
+		{"signature of another key", "PARTNER-0001.E1." + signature, ErrMalformed},
+		{"ed25519 tampered base", "API-0002" + strings.TrimPrefix(signedEd, "API-0001"), ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ring.Verify(tt.key); !errors.Is(err, tt.want) {
+				t.Errorf("Verify(%q) = %v, want %v", tt.key, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKeyringRejects(t *testing.T) {
+	otherPublic := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{9}, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+	tests := []struct {
+		name string
+		keys []Key
+	}{
+		{"empty id", []Key{{Group: "g", Algorithm: AlgorithmHMAC, Secret: hmacSecret}}},
+		{"long id", []Key{{ID: "K123456789", Group: "g", Algorithm: AlgorithmHMAC, Secret: hmacSecret}}},
+		{"lowercase id", []Key{{ID: "k1", Group: "g", Algorithm: AlgorithmHMAC, Secret: hmacSecret}}},
+		{"no group", []Key{{ID: "K1", Algorithm: AlgorithmHMAC, Secret: hmacSecret}}},
+		{"short secret", []Key{{ID: "K1", Group: "g", Algorithm: AlgorithmHMAC, Secret: []byte("short")}}},
+		{"unknown algorithm", []Key{{ID: "K1", Group: "g", Algorithm: "rsa", Secret: hmacSecret}}},
+		{"bad seed", []Key{{ID: "K1", Group: "g", Algorithm: AlgorithmEd25519, PrivateKey: []byte("seed")}}},
+		{"no public key", []Key{{ID: "K1", Group: "g", Algorithm: AlgorithmEd25519}}},
+		{"mismatched public key", []Key{{ID: "K1", Group: "g", Algorithm: AlgorithmEd25519, PrivateKey: edSeed, PublicKey: otherPublic}}},
+		{"repeated id", []Key{
+			{ID: "K1", Group: "g", Algorithm: AlgorithmHMAC, Secret: hmacSecret},
+			{ID: "K1", Group: "h", Algorithm: AlgorithmHMAC, Secret: hmacSecret},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.keys); !errors.Is(err, ErrBadSigningKey) {
+				t.Errorf("NewKeyring: %v, want ErrBadSigningKey", err)
+			}
+		})
+	}
+}
+
+func TestPublicKeyCannotSign(t *testing.T) {
+	public := Key{ID: "E1", Group: "api_key", Algorithm: AlgorithmEd25519,
+		PublicKey: ed25519.NewKeyFromSeed(edSeed).Public().(ed25519.PublicKey)}
+	ring := testKeyring(t, public)
+	if _, err := ring.Active("api_key"); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Active: %v, want ErrNoSigningKey", err)
+	}
+	if _, err := ring.keys["E1"].Sign("API-0001"); !errors.Is(err, ErrBadSigningKey) {
+		t.Errorf("Sign: %v, want ErrBadSigningKey", err)
+	}
+	if exported := ring.Keys()[0]; exported.PrivateKey != nil || exported.Secret != nil {
+		t.Error("Keys exposes secrets")
+	}
+}
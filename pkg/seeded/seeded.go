@@ -0,0 +1,49 @@
+// Package seeded выпускает ключи группы из seed так же, как POST /api/keys/generate
+// с параметром seed, но без сервиса и базы: QA собирает из них одинаковые фикстуры
+// на каждом прогоне. Для групп с подписью нужен тот же файл ключей подписи, что у
+// сервиса (keysign.LoadKeyring), - подпись ставит активный ключ группы.
+// Стоп-лист сервиса здесь не проверяется: сервис отклоняет seed, ключи которого
+// задевают стоп-лист, поэтому сохранённые им ключи всегда совпадают с Keys.
+package seeded
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/IvanChernomyrdin/avito-key-generate/internal/keygen"
+	"github.com/IvanChernomyrdin/avito-key-generate/pkg/keysign"
+)
+
+// Group - настройки группы, от которых зависят ключи: шаблон, контрольный символ,
+// алфавит, подпись и вид идентификатора
+type Group struct {
+	Name     string
+	Pattern  string
+	Checksum string
+	Alphabet string
+	// алгоритм подписи группы; подписывает активный ключ группы из keyring
+	Signature string
+	Kind      string
+}
+
+// ErrKindNotSupported - группы стандартных идентификаторов нельзя выпускать из seed
+var ErrKindNotSupported = errors.New("seeded generation is not supported for groups with kind")
+
+// Keys возвращает count ключей группы g для seed; signing нужен только группам с подписью
+func Keys(g Group, seed string, count int, signing *keysign.Keyring) ([]string, error) {
+	if g.Kind != "" {
+		return nil, ErrKindNotSupported
+	}
+	pattern, err := keygen.New(g.Pattern, g.Checksum, g.Alphabet)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := keygen.Seeded(g.Name, pattern, seed, count)
+	if err != nil || g.Signature == "" {
+		return keys, err
+	}
+	if signing == nil {
+		return nil, fmt.Errorf("%w %s", keysign.ErrNoSigningKey, g.Name)
+	}
+	return keygen.Sign(signing, g.Name, g.Signature, keys)
+}